@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/config"
 	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/queue"
@@ -32,32 +35,38 @@ func main() {
 
 	slog.Info("Logger initialized", "environment", cfg.Environment)
 
+	// SIGTERM/SIGINT останавливают прием новых задач, текущие дорабатывают
+	// в пределах SHUTDOWN_GRACE_PERIOD.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// 3 Initialize queue connection
-	rabbit, err := queue.NewRabbitMQConsumer(cfg.RabbitMQ)
+	rabbit, err := queue.NewRabbitBroker(cfg.RabbitMQ)
 	if err != nil {
-		slog.Error("Failed to initialize RabbitMQ consumer", "error", err)
+		slog.Error("Failed to initialize RabbitMQ broker", "error", err)
 		os.Exit(1)
 	}
 
-	slog.Info("RabbitMQ consumer initialized", "consumerName", cfg.RabbitMQ.ConsumerName, "producerName", cfg.RabbitMQ.ProducerName)
+	slog.Info("RabbitMQ broker initialized", "consumerName", cfg.RabbitMQ.ConsumerName, "producerName", cfg.RabbitMQ.ProducerName)
 
 	// 4 Initialize video storage connection
-	minioStorage, err := storage.NewMinioStorage(cfg.MinIO)
+	videoStorage, err := storage.New(ctx, cfg.Storage)
 	if err != nil {
-		slog.Error("Failed to initialize MinIO storage", "error", err)
+		slog.Error("Failed to initialize storage", "backend", cfg.Storage.Backend, "error", err)
 		os.Exit(1)
 	}
 
-	slog.Info("MinIO storage initialized", "endpoint", cfg.MinIO.Host, "bucket", cfg.MinIO.Port, "bucketName", cfg.MinIO.BucketName)
+	slog.Info("Storage initialized", "backend", cfg.Storage.Backend, "endpoint", cfg.Storage.MinIO.Host, "port", cfg.Storage.MinIO.Port, "bucketName", cfg.Storage.MinIO.BucketName, "localDir", cfg.Storage.LocalDir)
 
 	// 5 Initialize processor
-	process := task.NewVideoProcess()
+	process := task.NewVideoProcess(cfg.Process)
 
 	// 6 Run queue consumer
-	vs := services.NewVideoService(minioStorage, process)
+	vs := services.NewVideoService(videoStorage, process, cfg.Service)
 
 	slog.Info("Video service initialized and ready to run")
-	if err := rabbit.Run(vs); err != nil {
+	consumer := queue.NewConsumer(rabbit, cfg.RabbitMQ.Consumer)
+	if err := consumer.Run(ctx, vs); err != nil {
 		slog.Error("Failed to run service", "error", err)
 	}
 
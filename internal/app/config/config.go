@@ -2,14 +2,18 @@ package config
 
 import (
 	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/queue"
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/services"
 	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/storage"
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
 	"github.com/caarlos0/env/v11"
 )
 
 type Config struct {
 	Environment string                       `env:"APP_ENV" envDefault:"local"`
-	MinIO       storage.MinioConfig          `envDefault:""`
+	Storage     storage.Config               `envDefault:""`
 	RabbitMQ    queue.RabbitMQConsumerConfig `envDefault:""`
+	Process     task.ProcessConfig           `envDefault:""`
+	Service     services.Config              `envDefault:""`
 }
 
 func MustLoadConfig() Config {
@@ -20,5 +24,16 @@ func MustLoadConfig() Config {
 	if err != nil {
 		panic("failed to load config: " + err.Error())
 	}
+
+	// Бакеты сервиса по умолчанию совпадают с бакетом хранилища
+	if cfg.Service.SourceBucket == "" {
+		cfg.Service.SourceBucket = cfg.Storage.MinIO.BucketName
+	}
+	if cfg.Service.OutputBucket == "" {
+		cfg.Service.OutputBucket = cfg.Storage.MinIO.BucketName
+	}
+	if err := cfg.Service.Validate(); err != nil {
+		panic("invalid config: " + err.Error())
+	}
 	return cfg
 }
@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+	"github.com/google/uuid"
+)
+
+// cancelRegistry хранит выполняющиеся задачи и отмененные видео.
+// Отмена запоминается на ttl, чтобы задача, которая еще лежит в очереди
+// (или в очереди повторов), была пропущена, когда до нее дойдет очередь.
+// Пропускаются только задачи, попавшие в очередь до отмены: реплика не знает,
+// что лежит в общей очереди, и новая задача того же видео (повторная обработка)
+// не должна наткнуться на отмену, предназначенную прошлой.
+type cancelRegistry struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	running   map[uuid.UUID]map[*runningJob]struct{}
+	cancelled map[uuid.UUID]cancelMark
+}
+
+type cancelMark struct {
+	at      time.Time // когда пришла команда отмены
+	expires time.Time // когда забыть об отмене
+}
+
+type runningJob struct {
+	cancel context.CancelCauseFunc
+}
+
+func newCancelRegistry(ttl time.Duration) *cancelRegistry {
+	return &cancelRegistry{
+		ttl:       ttl,
+		running:   make(map[uuid.UUID]map[*runningJob]struct{}),
+		cancelled: make(map[uuid.UUID]cancelMark),
+	}
+}
+
+// track регистрирует выполняющуюся задачу. Возвращенный контекст отменяется
+// с причиной task.ErrCancelled, если придет команда отмены для videoID.
+// release нужно вызвать после завершения задачи.
+func (c *cancelRegistry) track(ctx context.Context, videoID uuid.UUID) (context.Context, func()) {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	job := &runningJob{cancel: cancel}
+
+	c.mu.Lock()
+	if c.running[videoID] == nil {
+		c.running[videoID] = make(map[*runningJob]struct{})
+	}
+	c.running[videoID][job] = struct{}{}
+	c.mu.Unlock()
+
+	release := func() {
+		c.mu.Lock()
+		delete(c.running[videoID], job)
+		if len(c.running[videoID]) == 0 {
+			delete(c.running, videoID)
+		}
+		c.mu.Unlock()
+		cancel(nil)
+	}
+	return jobCtx, release
+}
+
+// cancel отменяет выполняющиеся задачи videoID и запоминает отмену, сделанную в момент at,
+// для задач, которые попали в очередь раньше. Возвращает количество прерванных задач.
+func (c *cancelRegistry) cancel(videoID uuid.UUID, at time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for id, mark := range c.cancelled {
+		if now.After(mark.expires) {
+			delete(c.cancelled, id)
+		}
+	}
+	if mark, ok := c.cancelled[videoID]; !ok || at.After(mark.at) {
+		c.cancelled[videoID] = cancelMark{at: at, expires: now.Add(c.ttl)}
+	}
+
+	for job := range c.running[videoID] {
+		job.cancel(task.ErrCancelled)
+	}
+	return len(c.running[videoID])
+}
+
+// isCancelled сообщает, отменена ли задача videoID, попавшая в очередь в enqueuedAt.
+// Задачу с неизвестным временем постановки отмена из памяти не пропускает:
+// лучше выполнить отмененную задачу, чем потерять новую.
+func (c *cancelRegistry) isCancelled(videoID uuid.UUID, enqueuedAt time.Time) bool {
+	if enqueuedAt.IsZero() {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mark, ok := c.cancelled[videoID]
+	if !ok || time.Now().After(mark.expires) {
+		return false
+	}
+	return !enqueuedAt.After(mark.at)
+}
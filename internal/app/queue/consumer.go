@@ -0,0 +1,346 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+)
+
+type ConsumerConfig struct {
+	// Workers - количество задач, обрабатываемых параллельно.
+	Workers int `env:"RABBITMQ_WORKERS" envDefault:"1"`
+
+	// MaxAttempts - сколько раз задача выполняется, прежде чем уйти в dead-letter очередь.
+	MaxAttempts int `env:"RABBITMQ_MAX_ATTEMPTS" envDefault:"5"`
+	// RetryDelay - задержка перед первым повтором, дальше удваивается до RetryMaxDelay.
+	RetryDelay    time.Duration `env:"RABBITMQ_RETRY_DELAY" envDefault:"10s"`
+	RetryMaxDelay time.Duration `env:"RABBITMQ_RETRY_MAX_DELAY" envDefault:"10m"`
+
+	// ShutdownGracePeriod - сколько ждать завершения текущих задач при остановке,
+	// после чего они прерываются и возвращаются в очередь.
+	ShutdownGracePeriod time.Duration `env:"SHUTDOWN_GRACE_PERIOD" envDefault:"25s"`
+
+	// CancelTTL - сколько помнить отмену для задач, которые попали в очередь до нее.
+	CancelTTL time.Duration `env:"RABBITMQ_CANCEL_TTL" envDefault:"24h"`
+}
+
+type TaskHandler interface {
+	Execute(ctx context.Context, t task.VideoTask, progress task.ProgressFunc) (task.Output, error)
+}
+
+// Consumer читает задачи из Broker пулом воркеров, выполняет их через TaskHandler,
+// публикует результат и события и завершает каждое сообщение.
+// От транспорта он не зависит: с RabbitBroker работает в проде, с MemoryBroker - в тестах.
+type Consumer struct {
+	broker  Broker
+	cfg     ConsumerConfig
+	cancels *cancelRegistry
+}
+
+func NewConsumer(broker Broker, cfg ConsumerConfig) *Consumer {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	return &Consumer{
+		broker:  broker,
+		cfg:     cfg,
+		cancels: newCancelRegistry(cfg.CancelTTL),
+	}
+}
+
+// Run читает задачи, пока не будет отменен ctx.
+// После отмены новые сообщения больше не принимаются, а текущие задачи
+// получают ShutdownGracePeriod на завершение; оставшиеся прерываются
+// и возвращаются в очередь.
+func (c *Consumer) Run(ctx context.Context, handler TaskHandler) error {
+
+	// jobCtx не зависит от ctx: задачи прерываются только по истечении grace period.
+	jobCtx, abortJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer abortJobs()
+
+	deliveries, commands, err := c.broker.Consume(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Команды управления читаются отдельно: воркеры могут быть заняты,
+	// а отмена должна доходить до выполняющихся задач сразу.
+	go c.consumeControl(commands)
+
+	// Каждый воркер сам подтверждает или отклоняет свое сообщение,
+	// поэтому ack/nack всегда делает тот, кто выполнял задачу.
+	var wg sync.WaitGroup
+	for i := 0; i < c.cfg.Workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			logger := slog.With("worker", id)
+			for d := range deliveries {
+				c.handle(jobCtx, logger, d, handler)
+			}
+		}(i)
+	}
+	slog.Info("consumer started", "workers", c.cfg.Workers)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Info("consumer shutting down, draining in-flight tasks", "gracePeriod", c.cfg.ShutdownGracePeriod)
+		c.drain(done, abortJobs)
+	}
+
+	// Брокер закрываем только после воркеров: ack/nack идут через него.
+	if err := c.broker.Close(); err != nil {
+		slog.Warn("failed to close broker", "error", err)
+	}
+
+	slog.Info("consumer stopped")
+
+	return nil
+}
+
+// drain ждет завершения воркеров не дольше ShutdownGracePeriod,
+// после чего прерывает оставшиеся задачи и дожидается их остановки.
+func (c *Consumer) drain(done <-chan struct{}, abortJobs context.CancelFunc) {
+	timer := time.NewTimer(c.cfg.ShutdownGracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		slog.Info("all in-flight tasks finished")
+	case <-timer.C:
+		slog.Warn("shutdown grace period expired, aborting in-flight tasks", "gracePeriod", c.cfg.ShutdownGracePeriod)
+		abortJobs()
+		<-done
+	}
+}
+
+// consumeControl обрабатывает команды управления, пока канал не закроется.
+func (c *Consumer) consumeControl(commands <-chan task.ControlCommand) {
+	for cmd := range commands {
+		switch cmd.Action {
+		case task.ControlActionCancel:
+			at := cmd.RequestedAt
+			if at.IsZero() {
+				at = time.Now()
+			}
+			n := c.cancels.cancel(cmd.VideoID, at)
+			slog.Info("cancel command received", "videoID", cmd.VideoID, "runningTasks", n)
+		default:
+			slog.Warn("unknown control command", "action", cmd.Action, "videoID", cmd.VideoID)
+		}
+	}
+}
+
+// handle обрабатывает одно сообщение: разбирает задачу, выполняет ее,
+// публикует результат и подтверждает (или отклоняет) исходное сообщение.
+// По ходу публикуются события жизненного цикла задачи.
+func (c *Consumer) handle(ctx context.Context, logger *slog.Logger, d Delivery, handler TaskHandler) {
+	var vt task.VideoTask
+	err := json.Unmarshal(d.Body(), &vt)
+	if err != nil {
+		logger.Error("error unmarshal message in consume", "error", err, "body", string(d.Body()))
+		// Повтор не поможет, сразу в dead-letter очередь
+		if err := d.DeadLetter(ctx, task.NewJobError(task.ErrorClassInvalidTask, err)); err != nil {
+			logger.Error("error move message to dead-letter queue", "error", err)
+		}
+		return
+	}
+
+	attempt := d.Attempt()
+	logger = logger.With("videoID", vt.VideoID, "attempt", attempt)
+	logger.Info("message received", "body", vt)
+	c.publishEvent(ctx, logger, task.NewJobEvent(task.EventAccepted, vt, attempt))
+
+	if c.cancels.isCancelled(vt.VideoID, d.EnqueuedAt()) {
+		logger.Info("task cancelled before start, skipping")
+		c.publishEvent(ctx, logger, task.NewJobEvent(task.EventCancelled, vt, attempt))
+		c.ack(logger, d)
+		return
+	}
+
+	jobCtx, release := c.cancels.track(ctx, vt.VideoID)
+	defer release()
+	jobCtx, stopWatch := abortOnLost(jobCtx, d)
+	defer stopWatch()
+
+	c.publishEvent(ctx, logger, task.NewJobEvent(task.EventStarted, vt, attempt))
+	out, err := handler.Execute(jobCtx, vt, func(p task.Progress) {
+		ev := task.NewJobEvent(task.EventProgress, vt, attempt)
+		ev.Progress = &p
+		c.publishEvent(ctx, logger, ev)
+	})
+	if isLost(d) {
+		// Брокер уже вернул сообщение в очередь: результат опубликует тот, кто получит его снова
+		logger.Warn("broker delivery lost, leaving the task to redelivery", "error", err)
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		logger.Warn("task aborted on shutdown, requeueing", "error", err)
+		// Задачу доделает другая реплика
+		if err := d.Requeue(); err != nil {
+			logger.Error("error requeue message", "error", err)
+		}
+		return
+	}
+	if err != nil && errors.Is(context.Cause(jobCtx), task.ErrCancelled) {
+		logger.Info("task cancelled while running", "error", err)
+		c.publishEvent(ctx, logger, task.NewJobEvent(task.EventCancelled, vt, attempt))
+		c.ack(logger, d)
+		return
+	}
+	if err != nil {
+		logger.Error("error execute task", "error", err, "task", vt)
+		c.fail(ctx, logger, d, vt, err)
+		return
+	}
+	logger.Info("task executed successfully", "UserID", vt.UserID, "VideoID", vt.VideoID, "VideoTitle", vt.VideoTitle, "outputURL", out.URL, "storageKey", out.Key)
+
+	post := task.DBUpload{
+		VideoID:       vt.VideoID,
+		UserID:        vt.UserID,
+		VideoTitle:    vt.VideoTitle,
+		URL:           out.URL,
+		StorageBucket: out.Bucket,
+		StorageKey:    out.Key,
+		Revision:      out.Revision,
+	}
+
+	// Исходное сообщение подтверждаем только после того, как брокер принял результат.
+	if err := c.broker.Publish(ctx, post); err != nil {
+		logger.Error("error publish message", "error", err, "post", post)
+		c.fail(ctx, logger, d, vt, task.NewJobError(task.ErrorClassPublish, err))
+		return
+	}
+	logger.Info("message published", "post", post)
+
+	ev := task.NewJobEvent(task.EventCompleted, vt, attempt)
+	ev.Outputs = &task.JobOutputs{
+		MasterPlaylistURL: out.URL,
+		StorageBucket:     out.Bucket,
+		StorageKey:        out.Key,
+		Revision:          out.Revision,
+	}
+	c.publishEvent(ctx, logger, ev)
+
+	c.ack(logger, d)
+}
+
+// abortOnLost отменяет ctx с причиной ErrDeliveryLost, как только сообщение d
+// будет потеряно. Возвращенную функцию нужно вызвать после завершения задачи.
+func abortOnLost(ctx context.Context, d Delivery) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-d.Lost():
+			cancel(ErrDeliveryLost)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(nil) }
+}
+
+func isLost(d Delivery) bool {
+	select {
+	case <-d.Lost():
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Consumer) ack(logger *slog.Logger, d Delivery) {
+	if err := d.Ack(); err != nil {
+		logger.Error("error acknowledge message", "error", err)
+		return
+	}
+	logger.Info("message acknowledged")
+}
+
+// publishEvent публикует событие жизненного цикла задачи.
+// События информационные: ошибка только логируется, чтобы не задерживать обработку.
+func (c *Consumer) publishEvent(ctx context.Context, logger *slog.Logger, ev task.JobEvent) {
+	if err := c.broker.PublishEvent(ctx, ev); err != nil {
+		logger.Warn("error publish event", "error", err, "event", ev.Type)
+		return
+	}
+	logger.Debug("event published", "event", ev.Type)
+}
+
+// fail планирует повтор задачи (или отправляет ее в dead-letter очередь)
+// и публикует событие об ошибке.
+func (c *Consumer) fail(ctx context.Context, logger *slog.Logger, d Delivery, vt task.VideoTask, cause error) {
+	willRetry := c.retry(ctx, logger, d, cause)
+
+	ev := task.NewJobEvent(task.EventFailed, vt, d.Attempt())
+	ev.Error = &task.EventError{
+		Class:     task.ClassOf(cause),
+		Message:   cause.Error(),
+		WillRetry: willRetry,
+	}
+	c.publishEvent(ctx, logger, ev)
+}
+
+// retry планирует следующую попытку с экспоненциальной задержкой.
+// Если попытки исчерпаны, сообщение уходит в dead-letter очередь.
+// Возвращает false, если задача больше не будет выполняться.
+func (c *Consumer) retry(ctx context.Context, logger *slog.Logger, d Delivery, cause error) bool {
+	attempt := d.Attempt()
+	if attempt >= c.cfg.MaxAttempts {
+		logger.Warn("max attempts reached", "maxAttempts", c.cfg.MaxAttempts)
+		if err := d.DeadLetter(ctx, cause); err != nil {
+			// Брокер вернул сообщение в очередь, задача еще будет выполнена
+			logger.Error("error move message to dead-letter queue", "error", err)
+			return true
+		}
+		logger.Warn("message moved to dead-letter queue", "error", cause)
+		return false
+	}
+
+	delay := c.cfg.backoff(attempt)
+	if err := d.Retry(ctx, cause, delay); err != nil {
+		logger.Error("error schedule message for retry", "error", err)
+		return !errors.Is(err, ErrDeadLettered)
+	}
+	logger.Info("message scheduled for retry", "delay", delay, "retryCount", attempt)
+	return true
+}
+
+// backoff возвращает задержку перед повтором с номером attempt (начиная с 1).
+func (cfg ConsumerConfig) backoff(attempt int) time.Duration {
+	delay := cfg.RetryDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if cfg.RetryMaxDelay > 0 && delay >= cfg.RetryMaxDelay {
+			return cfg.RetryMaxDelay
+		}
+	}
+	return delay
+}
+
+// retryDelays возвращает все различные задержки повторов по возрастанию:
+// по одной на каждую попытку, после которой задача еще повторяется.
+func (cfg ConsumerConfig) retryDelays() []time.Duration {
+	var delays []time.Duration
+	for attempt := 1; attempt < max(cfg.MaxAttempts, 1); attempt++ {
+		delay := cfg.backoff(attempt)
+		if len(delays) == 0 || delays[len(delays)-1] != delay {
+			delays = append(delays, delay)
+		}
+	}
+	return delays
+}
@@ -0,0 +1,364 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+	"github.com/google/uuid"
+)
+
+// handlerFunc - TaskHandler из функции.
+type handlerFunc func(ctx context.Context, vt task.VideoTask, progress task.ProgressFunc) (task.Output, error)
+
+func (f handlerFunc) Execute(ctx context.Context, vt task.VideoTask, progress task.ProgressFunc) (task.Output, error) {
+	return f(ctx, vt, progress)
+}
+
+// startConsumer запускает Consumer.Run в фоне. Возвращенная функция останавливает
+// его и ждет, пока Run вернется.
+func startConsumer(t *testing.T, broker *MemoryBroker, cfg ConsumerConfig, handler TaskHandler) (stop func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewConsumer(broker, cfg).Run(ctx, handler)
+	}()
+
+	stopped := false
+	stop = func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Run() = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run() did not return after stop")
+		}
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func waitSettled(t *testing.T, broker *MemoryBroker, n int) []Settlement {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := broker.WaitSettled(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	return broker.Settlements()
+}
+
+func waitStarted(t *testing.T, started <-chan uuid.UUID, want uuid.UUID) {
+	t.Helper()
+	select {
+	case got := <-started:
+		if got != want {
+			t.Fatalf("started task %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("task %s did not start", want)
+	}
+}
+
+func newTask() task.VideoTask {
+	return task.VideoTask{VideoID: uuid.New(), UserID: 1, VideoTitle: "test"}
+}
+
+func eventsOf(broker *MemoryBroker, videoID uuid.UUID, eventType task.EventType) []task.JobEvent {
+	var out []task.JobEvent
+	for _, ev := range broker.Events() {
+		if ev.VideoID == videoID && ev.Type == eventType {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func TestConsumerSuccess(t *testing.T) {
+	broker := NewMemoryBroker()
+	vt := newTask()
+	out := task.Output{URL: "https://example.com/master.m3u8", Bucket: "videos", Key: "hls/master.m3u8", Revision: "r1"}
+	startConsumer(t, broker, ConsumerConfig{MaxAttempts: 3}, handlerFunc(
+		func(ctx context.Context, got task.VideoTask, progress task.ProgressFunc) (task.Output, error) {
+			return out, nil
+		}))
+
+	if err := broker.Enqueue(vt); err != nil {
+		t.Fatal(err)
+	}
+	settlements := waitSettled(t, broker, 1)
+
+	if len(settlements) != 1 || settlements[0].Kind != SettledAck {
+		t.Fatalf("settlements = %+v, want one ack", settlements)
+	}
+	published := broker.Published()
+	if len(published) != 1 {
+		t.Fatalf("published %d results, want 1", len(published))
+	}
+	if published[0].VideoID != vt.VideoID || published[0].URL != out.URL || published[0].StorageKey != out.Key {
+		t.Errorf("published %+v, want video %s with output %+v", published[0], vt.VideoID, out)
+	}
+	if n := len(eventsOf(broker, vt.VideoID, task.EventCompleted)); n != 1 {
+		t.Errorf("got %d completed events, want 1", n)
+	}
+}
+
+func TestConsumerRetryThenDeadLetter(t *testing.T) {
+	broker := NewMemoryBroker()
+	vt := newTask()
+	cfg := ConsumerConfig{MaxAttempts: 3, RetryDelay: time.Second, RetryMaxDelay: time.Minute}
+	failure := errors.New("boom")
+	startConsumer(t, broker, cfg, handlerFunc(
+		func(ctx context.Context, got task.VideoTask, progress task.ProgressFunc) (task.Output, error) {
+			return task.Output{}, failure
+		}))
+
+	if err := broker.Enqueue(vt); err != nil {
+		t.Fatal(err)
+	}
+	settlements := waitSettled(t, broker, 3)
+
+	want := []Settlement{
+		{Kind: SettledRetry, Attempt: 1, Delay: time.Second},
+		{Kind: SettledRetry, Attempt: 2, Delay: 2 * time.Second},
+		{Kind: SettledDeadLetter, Attempt: 3},
+	}
+	if len(settlements) != len(want) {
+		t.Fatalf("settlements = %+v, want %d", settlements, len(want))
+	}
+	for i, w := range want {
+		s := settlements[i]
+		if s.Kind != w.Kind || s.Attempt != w.Attempt || s.Delay != w.Delay {
+			t.Errorf("settlement %d = {%s attempt %d delay %s}, want {%s attempt %d delay %s}",
+				i, s.Kind, s.Attempt, s.Delay, w.Kind, w.Attempt, w.Delay)
+		}
+		if !errors.Is(s.Cause, failure) {
+			t.Errorf("settlement %d cause = %v, want %v", i, s.Cause, failure)
+		}
+	}
+	if n := len(broker.Published()); n != 0 {
+		t.Errorf("published %d results, want 0", n)
+	}
+
+	failed := eventsOf(broker, vt.VideoID, task.EventFailed)
+	if len(failed) != 3 {
+		t.Fatalf("got %d failed events, want 3", len(failed))
+	}
+	for i, ev := range failed {
+		if wantRetry := i < 2; ev.Error == nil || ev.Error.WillRetry != wantRetry {
+			t.Errorf("failed event %d error = %+v, want will_retry %v", i, ev.Error, wantRetry)
+		}
+	}
+}
+
+func TestConsumerInvalidBody(t *testing.T) {
+	broker := NewMemoryBroker()
+	var called atomic.Bool
+	startConsumer(t, broker, ConsumerConfig{MaxAttempts: 3}, handlerFunc(
+		func(ctx context.Context, got task.VideoTask, progress task.ProgressFunc) (task.Output, error) {
+			called.Store(true)
+			return task.Output{}, nil
+		}))
+
+	broker.EnqueueBody([]byte("{not json"))
+	settlements := waitSettled(t, broker, 1)
+
+	if len(settlements) != 1 || settlements[0].Kind != SettledDeadLetter {
+		t.Fatalf("settlements = %+v, want one dead letter", settlements)
+	}
+	if class := task.ClassOf(settlements[0].Cause); class != task.ErrorClassInvalidTask {
+		t.Errorf("dead letter class = %s, want %s", class, task.ErrorClassInvalidTask)
+	}
+	if called.Load() {
+		t.Error("handler called for an invalid message")
+	}
+}
+
+// TestConsumerCancel отменяет выполняющуюся задачу и задачу, которая ждет в очереди
+// за ней. Команды обрабатываются по порядку, поэтому отмена ожидающей задачи
+// отправляется первой и точно учтена к моменту, когда освободится воркер.
+func TestConsumerCancel(t *testing.T) {
+	broker := NewMemoryBroker()
+	running, queued := newTask(), newTask()
+	started := make(chan uuid.UUID, 2)
+	startConsumer(t, broker, ConsumerConfig{Workers: 1, MaxAttempts: 3, CancelTTL: time.Hour}, handlerFunc(
+		func(ctx context.Context, got task.VideoTask, progress task.ProgressFunc) (task.Output, error) {
+			started <- got.VideoID
+			<-ctx.Done()
+			return task.Output{}, context.Cause(ctx)
+		}))
+
+	if err := broker.Enqueue(running); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, started, running.VideoID)
+	if err := broker.Enqueue(queued); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Cancel(queued.VideoID); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Cancel(running.VideoID); err != nil {
+		t.Fatal(err)
+	}
+	settlements := waitSettled(t, broker, 2)
+
+	for i, s := range settlements {
+		if s.Kind != SettledAck {
+			t.Errorf("settlement %d = %s, want ack", i, s.Kind)
+		}
+	}
+	select {
+	case id := <-started:
+		t.Errorf("cancelled queued task %s was started", id)
+	default:
+	}
+	for _, vt := range []task.VideoTask{running, queued} {
+		if n := len(eventsOf(broker, vt.VideoID, task.EventCancelled)); n != 1 {
+			t.Errorf("task %s: got %d cancelled events, want 1", vt.VideoID, n)
+		}
+	}
+	if n := len(broker.Published()); n != 0 {
+		t.Errorf("published %d results, want 0", n)
+	}
+}
+
+func TestConsumerShutdownRequeues(t *testing.T) {
+	broker := NewMemoryBroker()
+	vt := newTask()
+	started := make(chan uuid.UUID, 1)
+	stop := startConsumer(t, broker, ConsumerConfig{MaxAttempts: 3, ShutdownGracePeriod: 10 * time.Millisecond}, handlerFunc(
+		func(ctx context.Context, got task.VideoTask, progress task.ProgressFunc) (task.Output, error) {
+			started <- got.VideoID
+			<-ctx.Done()
+			return task.Output{}, ctx.Err()
+		}))
+
+	if err := broker.Enqueue(vt); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, started, vt.VideoID)
+	stop()
+
+	settlements := broker.Settlements()
+	if len(settlements) != 1 || settlements[0].Kind != SettledRequeue {
+		t.Fatalf("settlements = %+v, want one requeue", settlements)
+	}
+	if n := broker.Pending(); n != 1 {
+		t.Errorf("pending = %d, want the requeued task back in the queue", n)
+	}
+	if n := len(eventsOf(broker, vt.VideoID, task.EventFailed)); n != 0 {
+		t.Errorf("got %d failed events for a requeued task, want 0", n)
+	}
+}
+
+// TestConsumerCancelAfterFinish проверяет, что отмена, пришедшая после завершения
+// задачи, не пропускает следующую задачу того же видео.
+func TestConsumerCancelAfterFinish(t *testing.T) {
+	broker := NewMemoryBroker()
+	vt := newTask()
+	var runs atomic.Int32
+	startConsumer(t, broker, ConsumerConfig{MaxAttempts: 3, CancelTTL: time.Hour}, handlerFunc(
+		func(ctx context.Context, got task.VideoTask, progress task.ProgressFunc) (task.Output, error) {
+			runs.Add(1)
+			return task.Output{Key: "hls/master.m3u8"}, nil
+		}))
+
+	if err := broker.Enqueue(vt); err != nil {
+		t.Fatal(err)
+	}
+	waitSettled(t, broker, 1)
+	if err := broker.Cancel(vt.VideoID); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Enqueue(vt); err != nil {
+		t.Fatal(err)
+	}
+	settlements := waitSettled(t, broker, 2)
+
+	for i, s := range settlements {
+		if s.Kind != SettledAck {
+			t.Errorf("settlement %d = %s, want ack", i, s.Kind)
+		}
+	}
+	if n := runs.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want 2", n)
+	}
+	if n := len(broker.Published()); n != 2 {
+		t.Errorf("published %d results, want 2", n)
+	}
+	if n := len(eventsOf(broker, vt.VideoID, task.EventCancelled)); n != 0 {
+		t.Errorf("got %d cancelled events, want 0", n)
+	}
+}
+
+// TestConsumerDeliveryLost проверяет, что при обрыве канала брокера задача
+// прерывается без публикации результата, а выполняет ее повторная доставка.
+func TestConsumerDeliveryLost(t *testing.T) {
+	broker := NewMemoryBroker()
+	vt := newTask()
+	started := make(chan uuid.UUID, 2)
+	causes := make(chan error, 1)
+	var runs atomic.Int32
+	startConsumer(t, broker, ConsumerConfig{MaxAttempts: 3}, handlerFunc(
+		func(ctx context.Context, got task.VideoTask, progress task.ProgressFunc) (task.Output, error) {
+			if runs.Add(1) > 1 {
+				return task.Output{Key: "hls/master.m3u8"}, nil
+			}
+			started <- got.VideoID
+			<-ctx.Done()
+			causes <- context.Cause(ctx)
+			return task.Output{}, ctx.Err()
+		}))
+
+	if err := broker.Enqueue(vt); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, started, vt.VideoID)
+	broker.LoseDeliveries()
+	settlements := waitSettled(t, broker, 1)
+
+	if cause := <-causes; !errors.Is(cause, ErrDeliveryLost) {
+		t.Errorf("first run cause = %v, want %v", cause, ErrDeliveryLost)
+	}
+	if len(settlements) != 1 || settlements[0].Kind != SettledAck || settlements[0].Attempt != 1 {
+		t.Fatalf("settlements = %+v, want one ack of attempt 1", settlements)
+	}
+	if n := len(broker.Published()); n != 1 {
+		t.Errorf("published %d results, want 1", n)
+	}
+	if n := len(eventsOf(broker, vt.VideoID, task.EventFailed)); n != 0 {
+		t.Errorf("got %d failed events, want 0", n)
+	}
+}
+
+// deadLetteringDelivery - сообщение, повтор которого брокер не смог запланировать
+// и отложил в dead-letter очередь.
+type deadLetteringDelivery struct {
+	Delivery
+}
+
+func (d deadLetteringDelivery) Attempt() int { return 1 }
+
+func (d deadLetteringDelivery) Retry(ctx context.Context, cause error, delay time.Duration) error {
+	return fmt.Errorf("%w: publish to retry queue failed", ErrDeadLettered)
+}
+
+func TestConsumerRetryDeadLettered(t *testing.T) {
+	c := NewConsumer(NewMemoryBroker(), ConsumerConfig{MaxAttempts: 3, RetryDelay: time.Second})
+	if c.retry(context.Background(), slog.Default(), deadLetteringDelivery{}, errors.New("boom")) {
+		t.Error("retry() = true after the message was dead-lettered, want false")
+	}
+}
@@ -0,0 +1,367 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+	"github.com/google/uuid"
+)
+
+// SettlementKind - чем завершилась обработка сообщения.
+type SettlementKind string
+
+const (
+	SettledAck        SettlementKind = "ack"
+	SettledRequeue    SettlementKind = "requeue"
+	SettledRetry      SettlementKind = "retry"
+	SettledDeadLetter SettlementKind = "dead_letter"
+)
+
+// Settlement - запись о том, как Consumer завершил сообщение.
+type Settlement struct {
+	Kind    SettlementKind
+	Body    []byte
+	Attempt int
+	Cause   error         // для Retry и DeadLetter
+	Delay   time.Duration // для Retry
+}
+
+// MemoryBroker - Broker в памяти для тестов потока сообщений без RabbitMQ.
+// Он запоминает, как было завершено каждое сообщение, и все опубликованные
+// результаты и события. Retry и Requeue сразу возвращают сообщение в очередь
+// (задержка только записывается), DeadLetter убирает его насовсем.
+// LoseDeliveries имитирует обрыв канала брокера.
+type MemoryBroker struct {
+	mu         sync.Mutex
+	pending    []*memoryDelivery
+	wake       chan struct{}
+	changed    chan struct{} // закрывается и пересоздается при каждой записи
+	consuming  bool
+	closed     bool
+	commands   chan task.ControlCommand
+	publishErr error
+	// lost - канал текущей "сессии", inflight - выданные и еще не завершенные сообщения
+	lost     chan struct{}
+	inflight map[*memoryDelivery]struct{}
+
+	settlements []Settlement
+	published   []task.DBUpload
+	events      []task.JobEvent
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		wake:     make(chan struct{}, 1),
+		changed:  make(chan struct{}),
+		commands: make(chan task.ControlCommand, 64),
+		lost:     make(chan struct{}),
+		inflight: make(map[*memoryDelivery]struct{}),
+	}
+}
+
+// Enqueue кладет задачу в очередь.
+func (b *MemoryBroker) Enqueue(vt task.VideoTask) error {
+	body, err := json.Marshal(vt)
+	if err != nil {
+		return fmt.Errorf("marshal task: %w", err)
+	}
+	b.EnqueueBody(body)
+	return nil
+}
+
+// EnqueueBody кладет в очередь произвольное тело сообщения,
+// например заведомо некорректное.
+func (b *MemoryBroker) EnqueueBody(body []byte) {
+	b.push(&memoryDelivery{broker: b, body: body, attempt: 1, enqueuedAt: time.Now()})
+}
+
+// Cancel отправляет команду отмены для videoID.
+func (b *MemoryBroker) Cancel(videoID uuid.UUID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errors.New("memory broker is closed")
+	}
+
+	select {
+	case b.commands <- task.ControlCommand{Action: task.ControlActionCancel, VideoID: videoID, RequestedAt: time.Now()}:
+		return nil
+	default:
+		return errors.New("memory broker control queue is full")
+	}
+}
+
+// LoseDeliveries имитирует обрыв канала: все выданные и еще не завершенные
+// сообщения становятся потерянными (Lost закрыт, завершить их нельзя)
+// и возвращаются в начало очереди с тем же номером попытки.
+func (b *MemoryBroker) LoseDeliveries() {
+	b.mu.Lock()
+	close(b.lost)
+	b.lost = make(chan struct{})
+	var redelivered []*memoryDelivery
+	for d := range b.inflight {
+		d.settled = true
+		redelivered = append(redelivered, &memoryDelivery{broker: b, body: d.body, attempt: d.attempt, enqueuedAt: d.enqueuedAt})
+	}
+	clear(b.inflight)
+	b.pending = append(redelivered, b.pending...)
+	b.mu.Unlock()
+	b.signal()
+}
+
+// FailPublish задает ошибку, которую будет возвращать Publish; nil отключает ее.
+func (b *MemoryBroker) FailPublish(err error) {
+	b.mu.Lock()
+	b.publishErr = err
+	b.mu.Unlock()
+}
+
+func (b *MemoryBroker) Consume(ctx context.Context) (<-chan Delivery, <-chan task.ControlCommand, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.consuming {
+		return nil, nil, errors.New("memory broker is already consuming")
+	}
+	b.consuming = true
+
+	deliveries := make(chan Delivery)
+	go b.consume(ctx, deliveries)
+
+	return deliveries, b.commands, nil
+}
+
+func (b *MemoryBroker) consume(ctx context.Context, deliveries chan<- Delivery) {
+	defer close(deliveries)
+
+	for {
+		d := b.pop()
+		if d == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.wake:
+				continue
+			}
+		}
+
+		select {
+		case deliveries <- d:
+		case <-ctx.Done():
+			b.pushFront(d) // Задача еще не начата, остается в очереди
+			return
+		}
+	}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, result task.DBUpload) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.publishErr != nil {
+		return b.publishErr
+	}
+	b.published = append(b.published, result)
+	b.notifyLocked()
+	return nil
+}
+
+func (b *MemoryBroker) PublishEvent(ctx context.Context, ev task.JobEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, ev)
+	b.notifyLocked()
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.commands)
+	}
+	return nil
+}
+
+// Settlements возвращает завершенные сообщения в порядке завершения.
+func (b *MemoryBroker) Settlements() []Settlement {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Settlement(nil), b.settlements...)
+}
+
+// Acks возвращает подтвержденные сообщения.
+func (b *MemoryBroker) Acks() []Settlement {
+	return b.filter(func(s Settlement) bool { return s.Kind == SettledAck })
+}
+
+// Nacks возвращает сообщения, завершенные без подтверждения:
+// возвращенные в очередь, отложенные на повтор и отправленные в dead-letter.
+func (b *MemoryBroker) Nacks() []Settlement {
+	return b.filter(func(s Settlement) bool { return s.Kind != SettledAck })
+}
+
+// Published возвращает опубликованные результаты.
+func (b *MemoryBroker) Published() []task.DBUpload {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]task.DBUpload(nil), b.published...)
+}
+
+// Events возвращает опубликованные события.
+func (b *MemoryBroker) Events() []task.JobEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]task.JobEvent(nil), b.events...)
+}
+
+// Pending возвращает количество сообщений, ожидающих в очереди.
+func (b *MemoryBroker) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// WaitSettled ждет, пока не наберется n завершенных сообщений, или отмены ctx.
+func (b *MemoryBroker) WaitSettled(ctx context.Context, n int) error {
+	for {
+		b.mu.Lock()
+		count, changed := len(b.settlements), b.changed
+		b.mu.Unlock()
+		if count >= n {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d settlements, got %d: %w", n, count, ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+func (b *MemoryBroker) filter(keep func(Settlement) bool) []Settlement {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []Settlement
+	for _, s := range b.settlements {
+		if keep(s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (b *MemoryBroker) push(d *memoryDelivery) {
+	b.mu.Lock()
+	b.pending = append(b.pending, d)
+	b.mu.Unlock()
+	b.signal()
+}
+
+func (b *MemoryBroker) pushFront(d *memoryDelivery) {
+	b.mu.Lock()
+	delete(b.inflight, d)
+	b.pending = append([]*memoryDelivery{d}, b.pending...)
+	b.mu.Unlock()
+	b.signal()
+}
+
+func (b *MemoryBroker) pop() *memoryDelivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 {
+		return nil
+	}
+	d := b.pending[0]
+	b.pending = b.pending[1:]
+	d.lost = b.lost
+	b.inflight[d] = struct{}{}
+	return d
+}
+
+func (b *MemoryBroker) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// settle записывает завершение сообщения; повторное завершение - ошибка.
+func (b *MemoryBroker) settle(d *memoryDelivery, s Settlement) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if isLost(d) {
+		return ErrDeliveryLost
+	}
+	if d.settled {
+		return errors.New("message already settled")
+	}
+	d.settled = true
+	delete(b.inflight, d)
+
+	s.Body = d.body
+	s.Attempt = d.attempt
+	b.settlements = append(b.settlements, s)
+	b.notifyLocked()
+	return nil
+}
+
+func (b *MemoryBroker) notifyLocked() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// memoryDelivery - сообщение MemoryBroker.
+type memoryDelivery struct {
+	broker     *MemoryBroker
+	body       []byte
+	attempt    int
+	enqueuedAt time.Time
+	lost       chan struct{} // канал сессии, в которой сообщение выдано
+	settled    bool
+}
+
+func (d *memoryDelivery) Body() []byte {
+	return d.body
+}
+
+func (d *memoryDelivery) Attempt() int {
+	return d.attempt
+}
+
+func (d *memoryDelivery) EnqueuedAt() time.Time {
+	return d.enqueuedAt
+}
+
+func (d *memoryDelivery) Lost() <-chan struct{} {
+	return d.lost
+}
+
+func (d *memoryDelivery) Ack() error {
+	return d.broker.settle(d, Settlement{Kind: SettledAck})
+}
+
+func (d *memoryDelivery) Requeue() error {
+	if err := d.broker.settle(d, Settlement{Kind: SettledRequeue}); err != nil {
+		return err
+	}
+	d.broker.push(&memoryDelivery{broker: d.broker, body: d.body, attempt: d.attempt, enqueuedAt: d.enqueuedAt})
+	return nil
+}
+
+func (d *memoryDelivery) Retry(ctx context.Context, cause error, delay time.Duration) error {
+	if err := d.broker.settle(d, Settlement{Kind: SettledRetry, Cause: cause, Delay: delay}); err != nil {
+		return err
+	}
+	d.broker.push(&memoryDelivery{broker: d.broker, body: d.body, attempt: d.attempt + 1, enqueuedAt: d.enqueuedAt})
+	return nil
+}
+
+func (d *memoryDelivery) DeadLetter(ctx context.Context, cause error) error {
+	return d.broker.settle(d, Settlement{Kind: SettledDeadLetter, Cause: cause})
+}
@@ -0,0 +1,148 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrPublishNotConfirmed = errors.New("publish was not confirmed by broker")
+
+// ErrPublishReturned - брокер вернул mandatory-сообщение: его некуда маршрутизировать
+// (например, очереди нет). Подтверждение брокер при этом все равно присылает.
+var ErrPublishReturned = errors.New("publish was returned by broker as unroutable")
+
+// returnsBuffer - емкость канала возвратов. Возврат приходит раньше подтверждения,
+// а разбирает возвраты тот, кто ждет подтверждения, так что канал не переполняется,
+// пока не застряло больше returnsBuffer публикаций одновременно.
+const returnsBuffer = 64
+
+// confirmPublisher держит один долгоживущий канал в режиме publisher confirms.
+// Канал переоткрывается лениво, если он закрылся (например, после переподключения).
+// amqp.Channel безопасен для конкурентной публикации, поэтому канал общий для всех воркеров.
+type confirmPublisher struct {
+	connection func() (*amqp.Connection, error)
+
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	returns  chan amqp.Return
+	returned map[string]amqp.Return // MessageId -> возврат, разобранный из returns
+}
+
+func newConfirmPublisher(connection func() (*amqp.Connection, error)) *confirmPublisher {
+	return &confirmPublisher{connection: connection, returned: make(map[string]amqp.Return)}
+}
+
+func (p *confirmPublisher) channel() (*amqp.Channel, chan amqp.Return, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, p.returns, nil
+	}
+
+	conn, err := p.connection()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed get connection (Producer) %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed create channel (Producer) %w", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed enable confirm mode (Producer) %w", err)
+	}
+
+	p.ch = ch
+	p.returns = ch.NotifyReturn(make(chan amqp.Return, returnsBuffer))
+	clear(p.returned)
+	return ch, p.returns, nil
+}
+
+// publish публикует сообщение и ждет подтверждения от брокера.
+// mandatory-сообщение, которое брокер не смог никуда доставить, считается
+// неопубликованным (ErrPublishReturned), хотя брокер его и подтвердил.
+func (p *confirmPublisher) publish(ctx context.Context, exchange, key string, mandatory bool, msg amqp.Publishing) error {
+	ch, returns, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	if mandatory && msg.MessageId == "" {
+		msg.MessageId = uuid.NewString() // по нему возврат сопоставляется с публикацией
+	}
+
+	dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, false, msg)
+	if err != nil {
+		p.reset(ch)
+		return fmt.Errorf("publish failed (Producer): %w", err)
+	}
+
+	acked, err := dc.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting publish confirm failed (Producer): %w", err)
+	}
+	if !acked {
+		return ErrPublishNotConfirmed
+	}
+
+	if mandatory {
+		if ret, ok := p.takeReturned(returns, msg.MessageId); ok {
+			return fmt.Errorf("%w: exchange %q, key %q: %d %s", ErrPublishReturned, exchange, key, ret.ReplyCode, ret.ReplyText)
+		}
+	}
+
+	return nil
+}
+
+// takeReturned сообщает, вернул ли брокер сообщение messageID.
+// Брокер отправляет basic.return до подтверждения, а библиотека кладет его
+// в буферизованный канал раньше, чем отдает подтверждение, поэтому после
+// подтверждения возврат (если он был) уже лежит в returns.
+func (p *confirmPublisher) takeReturned(returns <-chan amqp.Return, messageID string) (amqp.Return, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for drained := false; !drained; {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				drained = true
+				break
+			}
+			p.returned[ret.MessageId] = ret
+		default:
+			drained = true
+		}
+	}
+
+	ret, ok := p.returned[messageID]
+	delete(p.returned, messageID)
+	return ret, ok
+}
+
+// reset закрывает канал, чтобы следующая публикация открыла новый.
+func (p *confirmPublisher) reset(ch *amqp.Channel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ch == ch {
+		p.ch.Close()
+		p.ch = nil
+	}
+}
+
+func (p *confirmPublisher) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ch != nil {
+		p.ch.Close()
+		p.ch = nil
+	}
+}
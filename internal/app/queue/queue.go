@@ -1,16 +1,59 @@
 package queue
 
-import "github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+import (
+	"context"
+	"errors"
+	"time"
 
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+)
+
+// ErrDeliveryLost - причина отмены задачи, сообщение которой больше нельзя завершить.
+var ErrDeliveryLost = errors.New("broker delivery lost")
+
+// ErrDeadLettered - Retry не смог запланировать повтор и отложил сообщение
+// в dead-letter очередь: задача больше не будет выполняться.
+var ErrDeadLettered = errors.New("message moved to dead-letter queue")
+
+// Broker - транспорт задач и результатов, с которым работает Consumer.
 type Broker interface {
-	Consume() (task.VideoTask, error)
-	Publish(task.DBUpload) error
+	TaskConsumer
+	UpdatePublisher
 }
 
 type TaskConsumer interface {
-	Consume() (task.VideoTask, error)
+	// Consume начинает получать задачи и команды управления.
+	// Канал задач закрывается после отмены ctx; уже выданные Delivery
+	// остаются действительными до вызова Close.
+	Consume(ctx context.Context) (<-chan Delivery, <-chan task.ControlCommand, error)
+	// Close освобождает ресурсы брокера после того, как все Delivery обработаны.
+	Close() error
 }
 
 type UpdatePublisher interface {
-	Publish(task.DBUpload) error
+	// Publish публикует результат обработки и возвращается, когда брокер его принял.
+	Publish(ctx context.Context, result task.DBUpload) error
+	PublishEvent(ctx context.Context, ev task.JobEvent) error
+}
+
+// Delivery - полученное сообщение с задачей.
+// Ровно один из методов Ack, Requeue, Retry или DeadLetter завершает сообщение.
+type Delivery interface {
+	Body() []byte
+	// Attempt - номер попытки выполнения, начиная с 1.
+	Attempt() int
+	// EnqueuedAt - когда задача впервые попала в очередь (повторы его сохраняют);
+	// нулевое время - неизвестно.
+	EnqueuedAt() time.Time
+	// Lost закрывается, если сообщение больше нельзя завершить (потерян канал
+	// брокера): брокер сам вернет его в очередь, и задачу выполнит следующий получатель.
+	Lost() <-chan struct{}
+	Ack() error
+	// Requeue возвращает сообщение в очередь без увеличения номера попытки.
+	Requeue() error
+	// Retry планирует следующую попытку не раньше чем через delay.
+	// Ошибка с ErrDeadLettered означает, что повтора не будет.
+	Retry(ctx context.Context, cause error, delay time.Duration) error
+	// DeadLetter откладывает сообщение вместе с причиной ошибки в dead-letter очередь.
+	DeadLetter(ctx context.Context, cause error) error
 }
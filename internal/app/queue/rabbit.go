@@ -1,11 +1,19 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+	"github.com/google/uuid"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -18,19 +26,86 @@ type RabbitMQConsumerConfig struct {
 
 	ConsumerName string `env:"RABBITMQ_CONSUMER_NAME"`
 	ProducerName string `env:"RABBITMQ_PRODUCER_NAME"`
-}
 
-type TaskHandler interface {
-	Execute(t task.VideoTask) (string, error)
+	// Consumer - настройки обработки задач, общие для любого брокера.
+	Consumer ConsumerConfig `envDefault:""`
+
+	// Prefetch - сколько неподтвержденных сообщений брокер отдает consumer'у.
+	// 0 - равен количеству воркеров.
+	Prefetch int `env:"RABBITMQ_PREFETCH" envDefault:"0"`
+
+	// RetryQueue - префикс очередей задержки, по умолчанию <consumer>.retry.
+	// На каждую ступень задержки своя очередь <RetryQueue>.<задержка>, например tasks.retry.40s.
+	RetryQueue string `env:"RABBITMQ_RETRY_QUEUE"`
+	// DeadLetterQueue - очередь для задач, исчерпавших попытки, по умолчанию <consumer>.dlq.
+	DeadLetterQueue string `env:"RABBITMQ_DEAD_LETTER_QUEUE"`
+
+	// Границы задержки между попытками переподключения к брокеру.
+	ReconnectMinDelay time.Duration `env:"RABBITMQ_RECONNECT_MIN_DELAY" envDefault:"1s"`
+	ReconnectMaxDelay time.Duration `env:"RABBITMQ_RECONNECT_MAX_DELAY" envDefault:"30s"`
+
+	// PublishAttempts - сколько раз пытаться опубликовать сообщение, пока брокер его не подтвердит.
+	PublishAttempts int `env:"RABBITMQ_PUBLISH_ATTEMPTS" envDefault:"3"`
+	// PublishTimeout - сколько ждать подтверждения одной публикации.
+	PublishTimeout time.Duration `env:"RABBITMQ_PUBLISH_TIMEOUT" envDefault:"10s"`
+
+	// EventsExchange - topic exchange для событий жизненного цикла задач
+	// (routing key - VideoID). Если пусто, события не публикуются: в очереди
+	// producer'а их приняли бы за DBUpload.
+	EventsExchange string `env:"RABBITMQ_EVENTS_EXCHANGE"`
+
+	// Команды отмены (task.ControlCommand). Если задан ControlExchange (fanout),
+	// каждая реплика получает команды в свою временную очередь; иначе читается
+	// общая очередь ControlQueue. Если пусто и то и другое, отмена выключена.
+	ControlExchange string `env:"RABBITMQ_CONTROL_EXCHANGE"`
+	ControlQueue    string `env:"RABBITMQ_CONTROL_QUEUE"`
 }
 
-type RabbitConsumer struct {
-	Conn         *amqp.Connection
+// Заголовки, которыми помечаются повторно отправленные сообщения.
+const (
+	RetryCountHeader    = "x-retry-count"
+	LastErrorHeader     = "x-last-error"
+	OriginalQueueHeader = "x-original-queue"
+)
+
+// RabbitBroker - Broker поверх RabbitMQ: задачи читаются из очереди consumer'а,
+// результаты публикуются в очередь producer'а с подтверждением брокера.
+// Соединение и канал восстанавливаются автоматически.
+type RabbitBroker struct {
+	dsn string
+
+	mu   sync.RWMutex
+	conn *amqp.Connection
+
+	publisher       *confirmPublisher
+	publishAttempts int
+	publishTimeout  time.Duration
+
+	reconnects        atomic.Int64
+	reconnectMinDelay time.Duration
+	reconnectMaxDelay time.Duration
+
 	consumerName string // name
+	consumerTag  string
 	producerName string
+	prefetch     int
+
+	retryQueue      string
+	retryDelays     []time.Duration // задержки очередей задержки по возрастанию
+	deadLetterQueue string
+	eventsExchange  string
+
+	controlExchange string
+	controlQueue    string
+
+	// session - текущий канал consumer'а; после остановки Consume
+	// остается открытым до Close, чтобы воркеры могли подтвердить сообщения.
+	session   *consumeSession
+	commands  chan task.ControlCommand
+	controlWG sync.WaitGroup
 }
 
-func NewRabbitMQConsumer(cfg RabbitMQConsumerConfig) (*RabbitConsumer, error) {
+func NewRabbitBroker(cfg RabbitMQConsumerConfig) (*RabbitBroker, error) {
 	dsn := fmt.Sprintf(
 		"amqp://%s:%s@%s:%s",
 		cfg.User,
@@ -45,26 +120,164 @@ func NewRabbitMQConsumer(cfg RabbitMQConsumerConfig) (*RabbitConsumer, error) {
 		return nil, fmt.Errorf("failed to connect to RabbitMQ: %s %w", dsn, err)
 	}
 
-	return &RabbitConsumer{Conn: conn,
-		consumerName: cfg.ConsumerName,
-		producerName: cfg.ProducerName,
-	}, nil
+	prefetch := cfg.Prefetch
+	if prefetch < 1 {
+		prefetch = max(cfg.Consumer.Workers, 1)
+	}
+
+	retryQueue := cfg.RetryQueue
+	if retryQueue == "" {
+		retryQueue = cfg.ConsumerName + ".retry"
+	}
+	deadLetterQueue := cfg.DeadLetterQueue
+	if deadLetterQueue == "" {
+		deadLetterQueue = cfg.ConsumerName + ".dlq"
+	}
+
+	publishAttempts := cfg.PublishAttempts
+	if publishAttempts < 1 {
+		publishAttempts = 1
+	}
+
+	r := &RabbitBroker{
+		dsn:               dsn,
+		conn:              conn,
+		reconnectMinDelay: cfg.ReconnectMinDelay,
+		reconnectMaxDelay: cfg.ReconnectMaxDelay,
+		consumerName:      cfg.ConsumerName,
+		consumerTag:       cfg.ConsumerName + "-" + uuid.NewString(),
+		producerName:      cfg.ProducerName,
+		prefetch:          prefetch,
+		retryQueue:        retryQueue,
+		retryDelays:       cfg.Consumer.retryDelays(),
+		deadLetterQueue:   deadLetterQueue,
+		eventsExchange:    cfg.EventsExchange,
+		controlExchange:   cfg.ControlExchange,
+		controlQueue:      cfg.ControlQueue,
+
+		publishAttempts: publishAttempts,
+		publishTimeout:  cfg.PublishTimeout,
+	}
+	r.publisher = newConfirmPublisher(r.connection)
+
+	if r.eventsExchange == "" {
+		slog.Info("job events are disabled, set RABBITMQ_EVENTS_EXCHANGE to publish them")
+	}
+
+	return r, nil
+}
+
+// Reconnects возвращает, сколько раз брокер восстанавливал соединение или канал.
+func (r *RabbitBroker) Reconnects() int64 {
+	return r.reconnects.Load()
+}
+
+// connection возвращает живое соединение, при необходимости переподключаясь.
+func (r *RabbitBroker) connection() (*amqp.Connection, error) {
+	r.mu.RLock()
+	conn := r.conn
+	r.mu.RUnlock()
+	if conn != nil && !conn.IsClosed() {
+		return conn, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil && !r.conn.IsClosed() {
+		return r.conn, nil
+	}
+
+	conn, err := amqp.Dial(r.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect to RabbitMQ: %w", err)
+	}
+	r.conn = conn
+	return conn, nil
+}
+
+// reconnectDelay возвращает задержку перед попыткой переподключения номер attempt
+// (экспоненциальный рост со случайным разбросом, чтобы реплики не ломились одновременно).
+func (r *RabbitBroker) reconnectDelay(attempt int) time.Duration {
+	delay := r.reconnectMinDelay
+	for i := 1; i < attempt && delay < r.reconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > r.reconnectMaxDelay {
+		delay = r.reconnectMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// full jitter в диапазоне [delay/2, delay]
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// consumeSession - канал consumer'а и уведомления о его закрытии.
+type consumeSession struct {
+	ch         *amqp.Channel
+	msgs       <-chan amqp.Delivery
+	control    <-chan amqp.Delivery // nil, если отмена выключена
+	connClosed chan *amqp.Error
+	chClosed   chan *amqp.Error
+	// lost закрывается вместе с каналом или соединением: сообщения этой сессии
+	// больше нельзя подтвердить, брокер вернет их в очередь сам.
+	lost chan struct{}
+}
+
+// watch закрывает lost, когда закрывается канал или соединение сессии.
+func (s *consumeSession) watch() {
+	defer close(s.lost)
+	select {
+	case err := <-s.connClosed:
+		if err != nil {
+			slog.Warn("RabbitMQ connection closed", "error", err)
+		}
+	case err := <-s.chClosed:
+		if err != nil {
+			slog.Warn("RabbitMQ channel closed", "error", err)
+		}
+	}
 }
 
-func (r *RabbitConsumer) newConsumeChan(tag string) (<-chan amqp.Delivery, error) {
+func (r *RabbitBroker) newConsumeChan(tag string) (*consumeSession, error) {
 
 	logger := slog.With("queue", tag)
 
+	conn, err := r.connection()
+	if err != nil {
+		return nil, err
+	}
+
 	logger.Info("declaring channel")
-	ch, err := r.Conn.Channel()
+	ch, err := conn.Channel()
 	if err != nil {
 		return nil, fmt.Errorf("failed create channel (Consumer) %w", err)
 	}
 
 	logger.Info("channel declared")
+
+	// Ограничиваем количество сообщений "в полете", чтобы брокер не отдавал
+	// одному consumer'у больше задач, чем он может обработать параллельно.
+	if err := ch.Qos(r.prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed set QoS (Consumer) %w", err)
+	}
+
+	if err := r.declareRetryQueues(ch); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	if r.eventsExchange != "" {
+		err := ch.ExchangeDeclare(r.eventsExchange, amqp.ExchangeTopic, true, false, false, false, nil)
+		if err != nil {
+			ch.Close()
+			return nil, fmt.Errorf("failed declare events exchange %s (Consumer) %w", r.eventsExchange, err)
+		}
+	}
 	msgs, err := ch.Consume(
 		r.consumerName,
-		"",
+		r.consumerTag,
 		false,
 		false,
 		false,
@@ -72,95 +285,462 @@ func (r *RabbitConsumer) newConsumeChan(tag string) (<-chan amqp.Delivery, error
 		nil,
 	)
 	if err != nil {
+		ch.Close()
 		return nil, fmt.Errorf("failed get queued dilivery (Consumer) %w", err)
 	}
 
 	logger.Info("consume channel created", "queue", r.consumerName, "messages", len(msgs))
 
-	return msgs, nil
+	control, err := r.newControlChan(ch)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	session := &consumeSession{
+		ch:         ch,
+		msgs:       msgs,
+		control:    control,
+		connClosed: conn.NotifyClose(make(chan *amqp.Error, 1)),
+		chClosed:   ch.NotifyClose(make(chan *amqp.Error, 1)),
+		lost:       make(chan struct{}),
+	}
+	go session.watch()
+	return session, nil
 
 }
 
-func (r *RabbitConsumer) publish(name string, body []byte) error {
-	ch, err := r.Conn.Channel()
+// newControlChan подписывается на команды управления.
+// Команды подтверждаются автоматически: потерянная при падении отмена
+// не страшнее, чем задача, доделанная до конца.
+func (r *RabbitBroker) newControlChan(ch *amqp.Channel) (<-chan amqp.Delivery, error) {
+	queueName := r.controlQueue
+
+	switch {
+	case r.controlExchange != "":
+		err := ch.ExchangeDeclare(r.controlExchange, amqp.ExchangeFanout, true, false, false, false, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed declare control exchange %s (Consumer) %w", r.controlExchange, err)
+		}
+		// Временная очередь на реплику: команда отмены должна дойти до всех.
+		q, err := ch.QueueDeclare("", false, true, true, false, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed declare control queue (Consumer) %w", err)
+		}
+		if err := ch.QueueBind(q.Name, "", r.controlExchange, false, nil); err != nil {
+			return nil, fmt.Errorf("failed bind control queue to %s (Consumer) %w", r.controlExchange, err)
+		}
+		queueName = q.Name
+	case r.controlQueue != "":
+		if _, err := ch.QueueDeclare(r.controlQueue, true, false, false, false, nil); err != nil {
+			return nil, fmt.Errorf("failed declare control queue %s (Consumer) %w", r.controlQueue, err)
+		}
+	default:
+		return nil, nil
+	}
+
+	control, err := ch.Consume(queueName, "", true, false, false, false, nil)
 	if err != nil {
-		return fmt.Errorf("failed create channel (Producer) %w", err)
+		return nil, fmt.Errorf("failed consume control queue %s (Consumer) %w", queueName, err)
 	}
 
-	err = ch.Publish(
-		"",
-		r.producerName,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
-	)
+	slog.Info("control channel created", "queue", queueName, "exchange", r.controlExchange)
+	return control, nil
+}
+
+// forwardControl разбирает команды управления и передает их consumer'у,
+// пока канал не закроется.
+func (r *RabbitBroker) forwardControl(control <-chan amqp.Delivery) {
+	defer r.controlWG.Done()
+	for msg := range control {
+		var cmd task.ControlCommand
+		if err := json.Unmarshal(msg.Body, &cmd); err != nil {
+			slog.Error("error unmarshal control command", "error", err, "body", string(msg.Body))
+			continue
+		}
+		if cmd.RequestedAt.IsZero() {
+			cmd.RequestedAt = msg.Timestamp
+		}
+		r.commands <- cmd
+	}
+}
+
+// declareRetryQueues объявляет очереди задержки и dead-letter очередь.
+// У каждой очереди задержки свой x-message-ttl, после которого брокер возвращает
+// сообщения в основную очередь через default exchange. RabbitMQ снимает по TTL
+// только сообщения из головы очереди, поэтому с одинаковым TTL в очереди
+// сообщение с долгой задержкой не придерживает более короткие.
+func (r *RabbitBroker) declareRetryQueues(ch *amqp.Channel) error {
+	for _, delay := range r.retryDelays {
+		name := r.retryQueueName(delay)
+		_, err := ch.QueueDeclare(
+			name,
+			true,
+			false,
+			false,
+			false,
+			amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": r.consumerName,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("failed declare retry queue %s (Consumer) %w", name, err)
+		}
+	}
+
+	_, err := ch.QueueDeclare(r.deadLetterQueue, true, false, false, false, nil)
 	if err != nil {
-		return fmt.Errorf("publish failed (Producer): %w", err)
+		return fmt.Errorf("failed declare dead-letter queue %s (Consumer) %w", r.deadLetterQueue, err)
 	}
 
 	return nil
+}
 
+// retryQueueName возвращает имя очереди задержки для delay. Задержка входит в имя,
+// чтобы смена RABBITMQ_RETRY_DELAY не упиралась в уже объявленную очередь с другим TTL.
+func (r *RabbitBroker) retryQueueName(delay time.Duration) string {
+	return r.retryQueue + "." + delay.String()
 }
 
-func (r *RabbitConsumer) Run(handler TaskHandler) error {
+// retryQueueFor выбирает очередь задержки для delay: наименьшую ступень
+// не короче delay, а если таких нет - самую длинную.
+func (r *RabbitBroker) retryQueueFor(delay time.Duration) (string, bool) {
+	if len(r.retryDelays) == 0 {
+		return "", false
+	}
+	i, _ := slices.BinarySearch(r.retryDelays, delay)
+	i = min(i, len(r.retryDelays)-1)
+	return r.retryQueueName(r.retryDelays[i]), true
+}
 
-	consumChan, err := r.newConsumeChan(r.consumerName)
+// publish публикует сообщение в очередь через default exchange и ждет
+// подтверждения брокера, повторяя попытку при ошибке.
+func (r *RabbitBroker) publish(ctx context.Context, queueName string, msg amqp.Publishing) error {
+	var err error
+	for attempt := 1; attempt <= r.publishAttempts; attempt++ {
+		pubCtx, cancel := context.WithTimeout(ctx, r.publishTimeout)
+		// mandatory: если очереди нет, брокер вернет сообщение, а не потеряет его молча
+		err = r.publisher.publish(pubCtx, "", queueName, true, msg)
+		cancel()
+		if err == nil {
+			return nil
+		}
 
+		slog.Warn("publish attempt failed", "queue", queueName, "attempt", attempt, "error", err)
+		if attempt < r.publishAttempts {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("publish to %s aborted: %w", queueName, ctx.Err())
+			case <-time.After(r.reconnectDelay(attempt)):
+			}
+		}
+	}
+
+	return fmt.Errorf("publish to %s failed after %d attempts: %w", queueName, r.publishAttempts, err)
+}
+
+// Publish публикует результат обработки в очередь producer'а
+// и ждет подтверждения брокера.
+func (r *RabbitBroker) Publish(ctx context.Context, result task.DBUpload) error {
+	body, err := json.Marshal(result)
 	if err != nil {
-		return fmt.Errorf("failed to create consume channel (Run): %w", err)
+		return fmt.Errorf("marshal result: %w", err)
 	}
-	slog.Info("RabbitMQ consumer started", "queue", r.consumerName, "producer", r.producerName)
 
-	for msg := range consumChan {
+	return r.publish(ctx, r.producerName, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+}
+
+// PublishEvent публикует событие жизненного цикла задачи в EventsExchange.
+// События информационные: одна попытка без повторов. Без EventsExchange
+// события выключены и PublishEvent ничего не делает.
+func (r *RabbitBroker) PublishEvent(ctx context.Context, ev task.JobEvent) error {
+	if r.eventsExchange == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, r.publishTimeout)
+	defer cancel()
+	return r.publisher.publish(pubCtx, r.eventsExchange, ev.VideoID.String(), false, amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		Type:          "video.job." + string(ev.Type),
+		CorrelationId: ev.VideoID.String(),
+		Timestamp:     ev.Timestamp,
+		Body:          body,
+	})
+}
+
+// Consume запускает чтение очереди consumer'а в фоне.
+// При потере соединения или канала подписка восстанавливается с задержкой;
+// воркерам это не видно, им важен только общий канал задач.
+func (r *RabbitBroker) Consume(ctx context.Context) (<-chan Delivery, <-chan task.ControlCommand, error) {
+	if r.commands != nil {
+		return nil, nil, errors.New("RabbitMQ broker is already consuming")
+	}
+	deliveries := make(chan Delivery)
+	r.commands = make(chan task.ControlCommand)
+
+	go r.consume(ctx, deliveries)
+
+	return deliveries, r.commands, nil
+}
+
+func (r *RabbitBroker) consume(ctx context.Context, deliveries chan<- Delivery) {
+	defer close(deliveries)
 
-		var vt task.VideoTask
-		err := json.Unmarshal(msg.Body, &vt)
+	attempt := 0
+	recovering := false
+	for ctx.Err() == nil {
+		session, err := r.newConsumeChan(r.consumerName)
 		if err != nil {
-			slog.Error("error unmarshal message in consume", "error", err, "body", string(msg.Body))
-			msg.Nack(false, false) // Отменяем сообщение, если не удалось разобрать
+			attempt++
+			delay := r.reconnectDelay(attempt)
+			slog.Error("failed to create consume channel (Consume), retrying", "error", err, "attempt", attempt, "delay", delay)
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
 			continue
 		}
+		attempt = 0
+		r.session = session
 
-		slog.Info("message received", "queue", r.consumerName, "body", vt)
+		if recovering {
+			n := r.reconnects.Add(1)
+			slog.Info("RabbitMQ consumer recovered", "queue", r.consumerName, "reconnects", n)
+		}
+		slog.Info("RabbitMQ consumer started", "queue", r.consumerName, "producer", r.producerName, "prefetch", r.prefetch)
 
-		url, err := handler.Execute(vt)
-		if err != nil {
-			slog.Error("error execute task", "error", err, "task", vt)
-			msg.Nack(false, false) // Отменяем сообщение, если обработка не удалась
-			continue
+		if session.control != nil {
+			r.controlWG.Add(1)
+			go r.forwardControl(session.control)
 		}
-		slog.Info("task executed successfully", "UserID", vt.UserID, "VideoID", vt.VideoID, "VideoTitle", vt.VideoTitle, "outputURL", url)
 
-		post := task.DBUpload{
-			VideoID:    vt.VideoID,
-			UserID:     vt.UserID,
-			VideoTitle: vt.VideoTitle,
-			URL:        url,
+		r.dispatch(ctx, session, deliveries)
+		if ctx.Err() != nil {
+			break
 		}
 
-		body, err := json.Marshal(post)
-		if err != nil {
-			slog.Error("error marshal post", "error", err, "post", post)
-			msg.Nack(false, false) // Отменяем сообщение, если не удалось сериализовать
-			continue
+		session.ch.Close()
+		r.session = nil
+		recovering = true
+
+		slog.Warn("RabbitMQ consume channel lost, reconnecting", "queue", r.consumerName, "reconnects", r.Reconnects())
+	}
+
+	// Сообщения, которые брокер успел отдать по prefetch, но воркеры не взяли,
+	// вернутся в очередь при закрытии канала в Close.
+	if r.session != nil {
+		if err := r.session.ch.Cancel(r.consumerTag, false); err != nil {
+			slog.Warn("failed to cancel consumer", "error", err)
 		}
+	}
+}
 
-		err = r.publish(r.producerName, body)
-		if err != nil {
-			slog.Error("error publish message", "error", err, "body", string(body))
-			msg.Nack(false, false) // Отменяем сообщение, если публикация не удалась
-			continue
+// dispatch раздает сообщения воркерам, пока канал или соединение не закроются
+// либо пока не будет отменен ctx.
+// Если канал закрылся, сообщения, уже отданные воркерам, подтвердить
+// не получится: брокер вернет их в очередь сам, а Consumer по Lost прервет их задачи.
+func (r *RabbitBroker) dispatch(ctx context.Context, session *consumeSession, deliveries chan<- Delivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-session.msgs:
+			if !ok {
+				return
+			}
+			select {
+			case deliveries <- &rabbitDelivery{broker: r, session: session, msg: msg}:
+			case <-ctx.Done():
+				msg.Nack(false, true) // Задача еще не начата, возвращаем в очередь
+				return
+			}
+		case <-session.lost:
+			return
+		}
+	}
+}
+
+// Close закрывает канал consumer'а, publisher и соединение.
+// Вызывается после того, как канал задач из Consume закрыт и все Delivery обработаны.
+func (r *RabbitBroker) Close() error {
+	if r.session != nil {
+		r.session.ch.Close()
+		r.session = nil
+	}
+	if r.commands != nil {
+		r.controlWG.Wait()
+		close(r.commands)
+	}
+
+	r.publisher.close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil && !r.conn.IsClosed() {
+		if err := r.conn.Close(); err != nil {
+			return fmt.Errorf("failed to close RabbitMQ connection: %w", err)
 		}
-		slog.Info("message published", "body", "queue", r.producerName, string(body))
+	}
+
+	slog.Info("RabbitMQ broker closed", "queue", r.consumerName, "producer", r.producerName)
+	return nil
+}
+
+// rabbitDelivery - сообщение из очереди consumer'а.
+type rabbitDelivery struct {
+	broker  *RabbitBroker
+	session *consumeSession
+	msg     amqp.Delivery
+}
+
+func (d *rabbitDelivery) Body() []byte {
+	return d.msg.Body
+}
+
+func (d *rabbitDelivery) Attempt() int {
+	return retryCount(d.msg.Headers) + 1
+}
+
+// EnqueuedAt - свойство timestamp сообщения, которое задает отправитель задачи
+// (точность AMQP - секунда). Retry и DeadLetter его сохраняют.
+func (d *rabbitDelivery) EnqueuedAt() time.Time {
+	return d.msg.Timestamp
+}
+
+func (d *rabbitDelivery) Lost() <-chan struct{} {
+	return d.session.lost
+}
+
+func (d *rabbitDelivery) Ack() error {
+	return d.msg.Ack(false)
+}
+
+func (d *rabbitDelivery) Requeue() error {
+	return d.msg.Nack(false, true)
+}
+
+// Retry отправляет сообщение с увеличенным счетчиком попыток в очередь задержки
+// своей ступени. Если публикация не удалась, сообщение уходит в dead-letter очередь
+// (ошибка оборачивает ErrDeadLettered): возврат в основную очередь без счетчика
+// и задержки запускал бы обработку заново сразу и без конца. Если не удалась и она,
+// сообщение возвращается в основную очередь не раньше чем через delay.
+func (d *rabbitDelivery) Retry(ctx context.Context, cause error, delay time.Duration) error {
+	r := d.broker
+	queueName, ok := r.retryQueueFor(delay)
+	if !ok {
+		return d.retryFailed(ctx, cause, delay, fmt.Errorf("no retry queue for delay %s", delay))
+	}
 
-		msg.Ack(false)
-		slog.Info("message acknowledged", "queue", r.consumerName, "body", string(msg.Body))
+	headers := copyHeaders(d.msg.Headers)
+	headers[RetryCountHeader] = int32(d.Attempt())
+	headers[LastErrorHeader] = cause.Error()
+
+	// Без timestamp отправителя задача в очереди повторов хотя бы с этого момента
+	enqueuedAt := d.msg.Timestamp
+	if enqueuedAt.IsZero() {
+		enqueuedAt = time.Now()
+	}
+
+	err := r.publish(ctx, queueName, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  d.msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    enqueuedAt,
+		Body:         d.msg.Body,
+	})
+	if err != nil {
+		return d.retryFailed(ctx, cause, delay, fmt.Errorf("publish to retry queue %s: %w", queueName, err))
 	}
 
-	slog.Info("RabbitMQ consumer stopped", "queue", r.consumerName, "producer", r.producerName)
+	return d.msg.Ack(false)
+}
 
+// retryFailed завершает сообщение, которое не удалось отправить в очередь повторов.
+func (d *rabbitDelivery) retryFailed(ctx context.Context, cause error, delay time.Duration, retryErr error) error {
+	err := d.publishDeadLetter(ctx, errors.Join(cause, retryErr))
+	if err == nil {
+		if err := d.msg.Ack(false); err != nil {
+			return fmt.Errorf("%w: %w (ack: %w)", ErrDeadLettered, retryErr, err)
+		}
+		return fmt.Errorf("%w: %w", ErrDeadLettered, retryErr)
+	}
+	retryErr = errors.Join(retryErr, err)
+
+	// Брокер недоступен: не выполняем задачу заново раньше, чем через delay
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	case <-d.session.lost:
+		return retryErr // сообщение уже вернулось в очередь вместе с каналом
+	}
+	d.msg.Nack(false, true)
+	return retryErr
+}
+
+// DeadLetter публикует исходное сообщение вместе с последней ошибкой
+// в dead-letter очередь и подтверждает его.
+// Если публикация не удалась, сообщение возвращается в основную очередь.
+func (d *rabbitDelivery) DeadLetter(ctx context.Context, cause error) error {
+	if err := d.publishDeadLetter(ctx, cause); err != nil {
+		d.msg.Nack(false, true)
+		return err
+	}
+	return d.msg.Ack(false)
+}
+
+func (d *rabbitDelivery) publishDeadLetter(ctx context.Context, cause error) error {
+	r := d.broker
+	headers := copyHeaders(d.msg.Headers)
+	headers[RetryCountHeader] = int32(retryCount(d.msg.Headers))
+	headers[LastErrorHeader] = cause.Error()
+	headers[OriginalQueueHeader] = r.consumerName
+
+	err := r.publish(ctx, r.deadLetterQueue, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  d.msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    d.msg.Timestamp,
+		Body:         d.msg.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("publish to dead-letter queue %s: %w", r.deadLetterQueue, err)
+	}
 	return nil
 }
+
+// retryCount достает счетчик повторов из заголовков сообщения.
+func retryCount(headers amqp.Table) int {
+	switch v := headers[RetryCountHeader].(type) {
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	out := make(amqp.Table, len(headers)+3)
+	for k, v := range headers {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/storage"
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+	"github.com/google/uuid"
+)
+
+// fakeProcesser вместо ffmpeg пишет в outputDir мастер-плейлист с одним вариантом
+// и одним сегментом; содержимое сегмента - content.
+type fakeProcesser struct {
+	content string
+}
+
+func (p fakeProcesser) Probe(ctx context.Context, videoURL string) (task.VideoMetadata, error) {
+	return task.VideoMetadata{Width: 1280, Height: 720, Duration: time.Second}, nil
+}
+
+func (p fakeProcesser) TranscodeTimeout(duration time.Duration) time.Duration {
+	return time.Minute
+}
+
+func (p fakeProcesser) Process(ctx context.Context, t task.VideoTask, videoURL string, outputDir string, meta task.VideoMetadata, progress task.ProgressFunc) error {
+	files := map[string]string{
+		task.MastePLName:    "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\nstream_720p.m3u8\n",
+		"stream_720p.m3u8":  "#EXTM3U\n#EXTINF:6.0,\nsegment_720p_0.ts\n#EXT-X-ENDLIST\n",
+		"segment_720p_0.ts": p.content,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(outputDir, name), []byte(data), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// faultyStorage - LocalStorage, у которого Copy на ключ с суффиксом failCopy
+// и Upload на ключ с суффиксом failUpload завершаются ошибкой.
+type faultyStorage struct {
+	*storage.LocalStorage
+	root       string
+	failCopy   string
+	failUpload string
+}
+
+var (
+	errCopyFailed   = errors.New("copy failed")
+	errUploadFailed = errors.New("upload failed")
+)
+
+func (s *faultyStorage) Copy(ctx context.Context, pathSrc, pathDst string) error {
+	if s.failCopy != "" && strings.HasSuffix(pathDst, s.failCopy) {
+		return errCopyFailed
+	}
+	return s.LocalStorage.Copy(ctx, pathSrc, pathDst)
+}
+
+// Upload с ошибкой, как и MinIO, отдает ее только в Close.
+func (s *faultyStorage) Upload(ctx context.Context, pathUpload string, opts storage.UploadOptions) (storage.ObjectWriter, error) {
+	w, err := s.LocalStorage.Upload(ctx, pathUpload, opts)
+	if err != nil || s.failUpload == "" || !strings.HasSuffix(pathUpload, s.failUpload) {
+		return w, err
+	}
+	return failingWriter{w}, nil
+}
+
+type failingWriter struct {
+	storage.ObjectWriter
+}
+
+func (w failingWriter) Close() error {
+	return w.CloseWithError(errUploadFailed)
+}
+
+func newTestService(t *testing.T, cfg Config, content string) (*VideoService, *faultyStorage) {
+	t.Helper()
+	root := t.TempDir()
+	local, err := storage.NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &faultyStorage{LocalStorage: local, root: root}
+
+	cfg.StagingPrefix = ".staging/"
+	cfg.UploadTimeout = time.Minute
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewVideoService(st, fakeProcesser{content: content}, cfg), st
+}
+
+func readObject(t *testing.T, st storage.StorageStreamProvider, objectPath string) (string, error) {
+	t.Helper()
+	r, err := st.Download(context.Background(), objectPath)
+	if err != nil {
+		return "", err
+	}
+	defer r.(io.Closer).Close()
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+// TestExecuteFailedPromotion проверяет, что неудачная публикация новой ревизии
+// не трогает опубликованный ранее результат.
+func TestExecuteFailedPromotion(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		// файлы прошлого результата относительно OUTPUT_PREFIX
+		previous func(out task.Output) []string
+	}{
+		{
+			name:     "revision directory",
+			template: "{video_id}/{revision}/{file}",
+			previous: func(out task.Output) []string {
+				dir := strings.TrimSuffix(out.Key, task.MastePLName)
+				return []string{out.Key, dir + "stream_720p.m3u8", dir + "segment_720p_0.ts"}
+			},
+		},
+		{
+			name:     "in place",
+			template: "{video_id}/{rendition}/{file}",
+			previous: func(out task.Output) []string {
+				dir := strings.TrimSuffix(out.Key, task.MastePLName)
+				return []string{out.Key, dir + "720p/stream_720p.m3u8", dir + "720p/segment_720p_0.ts"}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.OutputKeyTemplate = tt.template
+			vs, st := newTestService(t, cfg, "first run")
+			vt := task.VideoTask{VideoID: uuid.New(), UserID: 1}
+			ctx := context.Background()
+
+			first, err := vs.Execute(ctx, vt, nil)
+			if err != nil {
+				t.Fatalf("first Execute: %v", err)
+			}
+
+			st.failCopy = "/" + task.MastePLName
+			vs.Processer = fakeProcesser{content: "second run"}
+			_, err = vs.Execute(ctx, vt, nil)
+			if !errors.Is(err, errCopyFailed) {
+				t.Fatalf("second Execute error = %v, want %v", err, errCopyFailed)
+			}
+			if class := task.ClassOf(err); class != task.ErrorClassStorage {
+				t.Errorf("second Execute error class = %s, want %s", class, task.ErrorClassStorage)
+			}
+
+			for _, key := range tt.previous(first) {
+				if _, err := readObject(t, st, first.Bucket+"/"+key); err != nil {
+					t.Errorf("previous output %s: %v", key, err)
+				}
+			}
+			pointer, err := readObject(t, st, "videos/hls/"+vt.VideoID.String()+"/current.json")
+			if err != nil {
+				t.Fatalf("pointer: %v", err)
+			}
+			var current RevisionPointer
+			if err := json.Unmarshal([]byte(pointer), &current); err != nil {
+				t.Fatalf("pointer: %v", err)
+			}
+			if current.Current != first.Revision || len(current.Revisions) != 1 {
+				t.Errorf("pointer = %+v, want only revision %s", current, first.Revision)
+			}
+			if entries, _ := os.ReadDir(filepath.Join(st.root, "videos", ".staging")); len(entries) != 0 {
+				t.Errorf("staging not removed: %v", entries)
+			}
+
+			// Кроме прошлого результата и указателя от второй обработки ничего не осталось
+			var files []string
+			err = filepath.WalkDir(filepath.Join(st.root, "videos", "hls"), func(p string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					rel, _ := filepath.Rel(filepath.Join(st.root, "videos"), p)
+					files = append(files, filepath.ToSlash(rel))
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(tt.previous(first))+1 {
+				t.Errorf("output files = %v, want only the previous revision and current.json", files)
+			}
+		})
+	}
+}
+
+// outputFiles возвращает пути всех объектов бакета videos в local storage.
+func outputFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	bucket := filepath.Join(root, "videos")
+	err := filepath.WalkDir(bucket, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(bucket, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestExecuteRoundTrip(t *testing.T) {
+	cfg := validConfig()
+	cfg.OutputKeyTemplate = "{video_id}/{revision}/{rendition}/{file}"
+	cfg.KeepRevisions = 2
+	cfg.UploadWorkers = 3
+	vs, st := newTestService(t, cfg, "segment data")
+	vt := task.VideoTask{VideoID: uuid.New(), UserID: 1}
+	videoDir := "hls/" + vt.VideoID.String() + "/"
+
+	var revisions []string
+	for run := 0; run < 3; run++ {
+		var mu sync.Mutex
+		var uploading []float64
+		out, err := vs.Execute(context.Background(), vt, func(p task.Progress) {
+			if p.Stage == task.StageUploading {
+				mu.Lock()
+				uploading = append(uploading, p.Percent)
+				mu.Unlock()
+			}
+		})
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		revisions = append(revisions, out.Revision)
+
+		root := videoDir + out.Revision + "/"
+		if out.Bucket != "videos" || out.Key != root+task.MastePLName || out.URL != out.Key {
+			t.Fatalf("run %d: output = %+v, want master %s with URL mode key", run, out, root+task.MastePLName)
+		}
+		if !slices.IsSorted(uploading) || uploading[len(uploading)-1] != 100 {
+			t.Errorf("run %d: upload progress = %v, want non-decreasing up to 100", run, uploading)
+		}
+
+		// Ссылки в плейлистах переписаны под раскладку по вариантам
+		master, err := readObject(t, st, "videos/"+out.Key)
+		if err != nil || !strings.Contains(master, "\n720p/stream_720p.m3u8\n") {
+			t.Errorf("run %d: master = %q, %v; want a link to 720p/stream_720p.m3u8", run, master, err)
+		}
+		variant, err := readObject(t, st, "videos/"+root+"720p/stream_720p.m3u8")
+		if err != nil || !strings.Contains(variant, "\nsegment_720p_0.ts\n") {
+			t.Errorf("run %d: variant = %q, %v; want a link to segment_720p_0.ts", run, variant, err)
+		}
+		if got, err := readObject(t, st, "videos/"+root+"720p/segment_720p_0.ts"); err != nil || got != "segment data" {
+			t.Errorf("run %d: segment = %q, %v", run, got, err)
+		}
+		time.Sleep(time.Second) // ревизии различаются временем с точностью до секунды
+	}
+
+	pointerData, err := readObject(t, st, "videos/"+videoDir+"current.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pointer RevisionPointer
+	if err := json.Unmarshal([]byte(pointerData), &pointer); err != nil {
+		t.Fatal(err)
+	}
+	if pointer.Current != revisions[2] || len(pointer.Revisions) != 2 ||
+		pointer.Revisions[0].Revision != revisions[2] || pointer.Revisions[1].Revision != revisions[1] {
+		t.Errorf("pointer = %+v, want current %s and history %v", pointer, revisions[2], revisions[1:])
+	}
+
+	// Самая старая ревизия удалена, staging пуст
+	files := outputFiles(t, st.root)
+	if len(files) != 2*3+1 {
+		t.Errorf("output files = %v, want two revisions of 3 files and current.json", files)
+	}
+	for _, f := range files {
+		if strings.Contains(f, revisions[0]) || strings.HasPrefix(f, ".staging/") {
+			t.Errorf("unexpected object %s left in storage", f)
+		}
+	}
+}
+
+// TestExecuteUploadFailure проверяет, что ошибка сохранения объекта, которую хранилище
+// отдает только в Close, проваливает задачу и не оставляет частичного результата.
+func TestExecuteUploadFailure(t *testing.T) {
+	for _, staging := range []string{".staging/", ""} {
+		t.Run("staging="+staging, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.UploadAttempts = 2
+			vs, st := newTestService(t, cfg, "segment data")
+			vs.cfg.StagingPrefix = staging
+			st.failUpload = "segment_720p_0.ts"
+
+			_, err := vs.Execute(context.Background(), task.VideoTask{VideoID: uuid.New(), UserID: 1}, nil)
+			if !errors.Is(err, errUploadFailed) {
+				t.Fatalf("Execute error = %v, want %v", err, errUploadFailed)
+			}
+			if class := task.ClassOf(err); class != task.ErrorClassStorage {
+				t.Errorf("error class = %s, want %s", class, task.ErrorClassStorage)
+			}
+			if files := outputFiles(t, st.root); len(files) != 0 {
+				t.Errorf("output files = %v, want none", files)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+)
+
+// Переменные OutputKeyTemplate.
+const (
+	KeyVarVideoID   = "{video_id}"
+	KeyVarUserID    = "{user_id}"
+	KeyVarProcessID = "{process_id}"
+	KeyVarRevision  = "{revision}"
+	KeyVarRendition = "{rendition}"
+	KeyVarFile      = "{file}"
+)
+
+// Validate проверяет, что по шаблону ключа у разных файлов и разных видео
+// получаются разные ключи, что ревизии можно чистить, не задевая чужих файлов,
+// и что URL воспроизведения и подпись плейлистов настроены.
+func (cfg Config) Validate() error {
+	if !strings.Contains(cfg.OutputKeyTemplate, KeyVarFile) {
+		return fmt.Errorf("OUTPUT_KEY_TEMPLATE %q must contain %s", cfg.OutputKeyTemplate, KeyVarFile)
+	}
+	if !strings.Contains(cfg.OutputKeyTemplate, KeyVarVideoID) && !strings.Contains(cfg.OutputKeyTemplate, KeyVarProcessID) {
+		return fmt.Errorf("OUTPUT_KEY_TEMPLATE %q must contain %s or %s", cfg.OutputKeyTemplate, KeyVarVideoID, KeyVarProcessID)
+	}
+
+	// Объект исходника "<SourcePrefix><video_id>" и директория "<OutputPrefix><video_id>/"
+	// в одном бакете конфликтуют: в S3 это ломает листинг, в local backend - MkdirAll.
+	if cfg.SourceBucket == cfg.OutputBucket && cfg.SourcePrefix == cfg.OutputPrefix {
+		templates := []struct{ name, value string }{
+			{"OUTPUT_KEY_TEMPLATE", cfg.OutputKeyTemplate},
+			{"OUTPUT_POINTER_TEMPLATE", cfg.OutputPointerTemplate},
+		}
+		for _, t := range templates {
+			if strings.HasPrefix(t.value, KeyVarVideoID+"/") {
+				return fmt.Errorf("%s %q starts with %s/ while source and output share bucket %q and prefix %q: set OUTPUT_PREFIX or SOURCE_PREFIX",
+					t.name, t.value, KeyVarVideoID, cfg.OutputBucket, cfg.OutputPrefix)
+			}
+		}
+	}
+
+	if cfg.OutputPointerTemplate != "" {
+		if !strings.Contains(cfg.OutputPointerTemplate, KeyVarVideoID) {
+			return fmt.Errorf("OUTPUT_POINTER_TEMPLATE %q must contain %s", cfg.OutputPointerTemplate, KeyVarVideoID)
+		}
+		for _, v := range []string{KeyVarProcessID, KeyVarRevision, KeyVarRendition, KeyVarFile} {
+			if strings.Contains(cfg.OutputPointerTemplate, v) {
+				return fmt.Errorf("OUTPUT_POINTER_TEMPLATE %q must not contain %s", cfg.OutputPointerTemplate, v)
+			}
+		}
+	}
+	if cfg.KeepRevisions > 0 {
+		if cfg.OutputPointerTemplate == "" {
+			return fmt.Errorf("OUTPUT_KEEP_REVISIONS requires OUTPUT_POINTER_TEMPLATE")
+		}
+		// Без отдельной директории на ревизию GC удалил бы чужие файлы
+		if _, ok := revisionDir(cfg.OutputKeyTemplate); !ok {
+			return fmt.Errorf("OUTPUT_KEEP_REVISIONS requires OUTPUT_KEY_TEMPLATE with %s/ or %s/ as a path segment", KeyVarRevision, KeyVarProcessID)
+		}
+	}
+
+	if cfg.PresignPlaylists && cfg.PresignPlaylistExpiry <= 0 {
+		return fmt.Errorf("UPLOAD_PRESIGN_EXPIRY must be positive when UPLOAD_PRESIGN_PLAYLISTS is set")
+	}
+
+	switch cfg.PlaybackURLMode {
+	case PlaybackURLKey, PlaybackURLPresigned:
+	case PlaybackURLPublic:
+		if cfg.PlaybackBaseURL == "" {
+			return fmt.Errorf("PLAYBACK_BASE_URL is required for PLAYBACK_URL_MODE=%s", PlaybackURLPublic)
+		}
+	default:
+		return fmt.Errorf("unknown PLAYBACK_URL_MODE %q (want %s, %s or %s)", cfg.PlaybackURLMode, PlaybackURLKey, PlaybackURLPublic, PlaybackURLPresigned)
+	}
+	return nil
+}
+
+// sourcePath возвращает путь исходного видео в хранилище: "<bucket>/<prefix><video_id>".
+func (vs *VideoService) sourcePath(vt task.VideoTask) string {
+	return vs.cfg.SourceBucket + "/" + vs.cfg.SourcePrefix + vt.VideoID.String()
+}
+
+// outputLayout раскладывает файлы результата одной обработки по ключам в хранилище.
+type outputLayout struct {
+	bucket   string
+	prefix   string
+	template string
+	vars     []string // пары "переменная, значение" для strings.NewReplacer
+	// staging - префикс, под которым файлы лежат до публикации; пусто - без staging.
+	staging string
+	// pointerTemplate - шаблон ключа указателя на текущую ревизию; пусто - без указателя.
+	pointerTemplate string
+}
+
+func (vs *VideoService) newOutputLayout(vt task.VideoTask, processID, revision string) outputLayout {
+	return outputLayout{
+		bucket:   vs.cfg.OutputBucket,
+		prefix:   vs.cfg.OutputPrefix,
+		template: vs.cfg.OutputKeyTemplate,
+		vars: []string{
+			KeyVarVideoID, vt.VideoID.String(),
+			KeyVarUserID, strconv.FormatInt(vt.UserID, 10),
+			KeyVarProcessID, processID,
+			KeyVarRevision, revision,
+		},
+		staging:         stagingPrefix(vs.cfg.StagingPrefix, processID),
+		pointerTemplate: vs.cfg.OutputPointerTemplate,
+	}
+}
+
+func stagingPrefix(prefix, processID string) string {
+	if prefix == "" {
+		return ""
+	}
+	return prefix + processID + "/"
+}
+
+// key возвращает ключ объекта для файла relPath (путь внутри директории результата).
+// Пустые сегменты пути схлопываются: "{rendition}/{file}" для мастер-плейлиста
+// дает просто "master.m3u8".
+func (l outputLayout) key(relPath string) string {
+	vars := slices.Concat(l.vars, []string{
+		KeyVarRendition, task.RenditionOf(relPath),
+		KeyVarFile, relPath,
+	})
+	return l.render(l.template, vars)
+}
+
+func (l outputLayout) render(template string, vars []string) string {
+	key := strings.NewReplacer(vars...).Replace(template)
+	return l.prefix + strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+// pointerPath возвращает путь указателя на текущую ревизию видео
+// или пустую строку, если указатель выключен.
+func (l outputLayout) pointerPath() string {
+	if l.pointerTemplate == "" {
+		return ""
+	}
+	return l.bucket + "/" + l.render(l.pointerTemplate, l.vars)
+}
+
+// revisionRoot возвращает ключ директории, в которой лежат все файлы этой ревизии,
+// или пустую строку, если у ревизии нет своей директории.
+func (l outputLayout) revisionRoot() string {
+	dir, ok := revisionDir(l.template)
+	if !ok {
+		return ""
+	}
+	return l.render(dir, l.vars)
+}
+
+// revisionDir возвращает часть шаблона до {revision} (или {process_id}) включительно,
+// если эта переменная занимает целый сегмент пути и за ней идут остальные сегменты.
+func revisionDir(template string) (string, bool) {
+	for _, v := range []string{KeyVarRevision, KeyVarProcessID} {
+		i := strings.Index(template, v)
+		if i < 0 {
+			continue
+		}
+		end := i + len(v)
+		if (i == 0 || template[i-1] == '/') && strings.HasPrefix(template[end:], "/") {
+			return template[:end], true
+		}
+	}
+	return "", false
+}
+
+// objectPath возвращает путь объекта в хранилище: "<bucket>/<key>".
+func (l outputLayout) objectPath(relPath string) string {
+	return l.bucket + "/" + l.key(relPath)
+}
+
+// stagingPath возвращает путь, под которым файл выгружается до публикации:
+// "<bucket>/<staging><key>", или objectPath, если staging выключен.
+func (l outputLayout) stagingPath(relPath string) string {
+	return l.bucket + "/" + l.staging + l.key(relPath)
+}
+
+// stagingRoot возвращает путь "<bucket>/<staging>" для удаления всех staging-объектов.
+func (l outputLayout) stagingRoot() string {
+	return l.bucket + "/" + strings.TrimSuffix(l.staging, "/")
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+	"github.com/google/uuid"
+)
+
+func validConfig() Config {
+	return Config{
+		SourceBucket:          "videos",
+		OutputBucket:          "videos",
+		OutputPrefix:          "hls/",
+		OutputKeyTemplate:     "{video_id}/{revision}/{file}",
+		OutputPointerTemplate: "{video_id}/current.json",
+		PlaybackURLMode:       PlaybackURLKey,
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string // пусто - ошибки нет
+	}{
+		{name: "defaults"},
+		{
+			name:    "no file",
+			modify:  func(c *Config) { c.OutputKeyTemplate = "{video_id}/{revision}" },
+			wantErr: "must contain {file}",
+		},
+		{
+			name:    "source and output collide",
+			modify:  func(c *Config) { c.OutputPrefix = "" },
+			wantErr: "OUTPUT_KEY_TEMPLATE",
+		},
+		{
+			name: "pointer collides with source",
+			modify: func(c *Config) {
+				c.OutputPrefix = ""
+				c.OutputKeyTemplate = "{revision}/{video_id}/{file}"
+			},
+			wantErr: "OUTPUT_POINTER_TEMPLATE",
+		},
+		{
+			name: "same bucket, different prefixes",
+			modify: func(c *Config) {
+				c.SourcePrefix = "src/"
+				c.OutputPrefix = ""
+			},
+		},
+		{
+			name: "different buckets",
+			modify: func(c *Config) {
+				c.OutputBucket = "hls"
+				c.OutputPrefix = ""
+			},
+		},
+		{
+			name: "keep revisions without revision dir",
+			modify: func(c *Config) {
+				c.OutputKeyTemplate = "{video_id}/{revision}-{file}"
+				c.KeepRevisions = 2
+			},
+			wantErr: "OUTPUT_KEEP_REVISIONS",
+		},
+		{
+			name:    "public without base url",
+			modify:  func(c *Config) { c.PlaybackURLMode = PlaybackURLPublic },
+			wantErr: "PLAYBACK_BASE_URL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			if tt.modify != nil {
+				tt.modify(&cfg)
+			}
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOutputLayoutKey(t *testing.T) {
+	vt := task.VideoTask{VideoID: uuid.MustParse("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"), UserID: 7}
+	const vid = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+
+	tests := []struct {
+		name     string
+		prefix   string
+		template string
+		file     string
+		want     string
+	}{
+		{"default master", "hls/", "{video_id}/{revision}/{file}", "master.m3u8", "hls/" + vid + "/rev1/master.m3u8"},
+		{"default segment", "hls/", "{video_id}/{revision}/{file}", "segment_720p_3.ts", "hls/" + vid + "/rev1/segment_720p_3.ts"},
+		{"rendition variant", "", "{video_id}/{rendition}/{file}", "stream_720p.m3u8", vid + "/720p/stream_720p.m3u8"},
+		{"rendition segment", "", "{video_id}/{rendition}/{file}", "segment_720p_3.ts", vid + "/720p/segment_720p_3.ts"},
+		{"rendition master", "", "{video_id}/{rendition}/{file}", "master.m3u8", vid + "/master.m3u8"},
+		{"user and process", "out/", "{user_id}/{process_id}/{file}", "master.m3u8", "out/7/pid1/master.m3u8"},
+		{"extra slashes", "", "/{video_id}//{revision}/{file}", "master.m3u8", vid + "/rev1/master.m3u8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.OutputPrefix = tt.prefix
+			cfg.OutputKeyTemplate = tt.template
+			vs := &VideoService{cfg: cfg}
+			layout := vs.newOutputLayout(vt, "pid1", "rev1")
+
+			if got := layout.key(tt.file); got != tt.want {
+				t.Errorf("key(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+			if got, want := layout.objectPath(tt.file), "videos/"+tt.want; got != want {
+				t.Errorf("objectPath(%q) = %q, want %q", tt.file, got, want)
+			}
+		})
+	}
+}
+
+func TestRevisionDir(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+		wantOK   bool
+	}{
+		{"{video_id}/{revision}/{file}", "{video_id}/{revision}", true},
+		{"{video_id}/{process_id}/{rendition}/{file}", "{video_id}/{process_id}", true},
+		{"{revision}/{video_id}/{file}", "{revision}", true},
+		{"{video_id}/{revision}-{file}", "", false},
+		{"{video_id}/v{revision}/{file}", "", false},
+		{"{video_id}/{revision}", "", false},
+		{"{video_id}/{file}", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := revisionDir(tt.template)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("revisionDir(%q) = %q, %v; want %q, %v", tt.template, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Значения Config.PlaybackURLMode.
+const (
+	PlaybackURLKey       = "key"
+	PlaybackURLPublic    = "public"
+	PlaybackURLPresigned = "presigned"
+)
+
+// playbackURL возвращает URL мастер-плейлиста для каталога в выбранном режиме.
+func (vs *VideoService) playbackURL(ctx context.Context, bucket, key string) (string, error) {
+	switch vs.cfg.PlaybackURLMode {
+	case PlaybackURLKey:
+		return key, nil
+	case PlaybackURLPublic:
+		return strings.TrimSuffix(vs.cfg.PlaybackBaseURL, "/") + "/" + key, nil
+	case PlaybackURLPresigned:
+		return vs.storage.GetPresignedURL(ctx, bucket+"/"+key, vs.cfg.PlaybackURLExpiry)
+	default:
+		return "", fmt.Errorf("unknown playback URL mode %q", vs.cfg.PlaybackURLMode)
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/storage"
+)
+
+// uriAttr - атрибут URI="..." в тегах плейлиста (EXT-X-MEDIA, EXT-X-MAP, EXT-X-KEY и т.п.).
+var uriAttr = regexp.MustCompile(`URI="([^"]*)"`)
+
+// rewritePlaylist заменяет каждую ссылку в HLS-плейлисте на результат rewrite:
+// и строки-URI (варианты, сегменты), и атрибуты URI="..." в тегах.
+func rewritePlaylist(data []byte, rewrite func(uri string) (string, error)) ([]byte, error) {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#"):
+			var rewriteErr error
+			line = uriAttr.ReplaceAllStringFunc(line, func(attr string) string {
+				uri := uriAttr.FindStringSubmatch(attr)[1]
+				newURI, err := rewrite(uri)
+				if err != nil {
+					rewriteErr = err
+					return attr
+				}
+				return `URI="` + newURI + `"`
+			})
+			if rewriteErr != nil {
+				return nil, rewriteErr
+			}
+		default:
+			newURI, err := rewrite(trimmed)
+			if err != nil {
+				return nil, err
+			}
+			line = newURI
+		}
+
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read playlist: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// rewritePlaylistFile переписывает плейлист на месте и возвращает его новый размер.
+func rewritePlaylistFile(file string, rewrite func(uri string) (string, error)) (int64, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, fmt.Errorf("read playlist %s: %w", file, err)
+	}
+	data, err = rewritePlaylist(data, rewrite)
+	if err != nil {
+		return 0, fmt.Errorf("rewrite playlist %s: %w", file, err)
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return 0, fmt.Errorf("write playlist %s: %w", file, err)
+	}
+	return int64(len(data)), nil
+}
+
+// isRelativeURI сообщает, что ссылка указывает на файл рядом с плейлистом,
+// а не на абсолютный URL или путь от корня.
+func isRelativeURI(uri string) bool {
+	return uri != "" && !strings.HasPrefix(uri, "/") && !strings.Contains(uri, "://")
+}
+
+// relativeKey возвращает путь к ключу target относительно "директории" fromDir
+// (клиенты разрешают его так же, как относительный URL).
+func relativeKey(fromDir, target string) string {
+	from := splitKey(fromDir)
+	to := splitKey(target)
+
+	common := 0
+	for common < len(from) && common < len(to)-1 && from[common] == to[common] {
+		common++
+	}
+
+	parts := make([]string, 0, len(from)-common+len(to)-common)
+	for range from[common:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, to[common:]...)
+	return strings.Join(parts, "/")
+}
+
+func splitKey(key string) []string {
+	key = strings.Trim(path.Clean("/"+key), "/")
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, "/")
+}
+
+// PlaylistURIMapper возвращает URI, по которому клиент получит вложенный плейлист
+// objectPath ("<bucket>/<key>"), например URL эндпоинта API, который сам отдаст его
+// через PresignPlaylist.
+type PlaylistURIMapper func(objectPath string) (string, error)
+
+// PresignPlaylist читает плейлист playlistPath ("<bucket>/<key>") из хранилища и возвращает
+// его копию для отдачи из приватного бакета по запросу API: относительные ссылки на сегменты
+// и прочие файлы заменяются на presigned URL со сроком expiry, а ссылки на плейлисты
+// (варианты в мастер-плейлисте) - на результат mapPlaylist. Presigned URL вариантного
+// плейлиста вел бы прямо в хранилище, и его относительные ссылки на сегменты отдавали бы 403.
+// mapPlaylist может быть nil для вариантных плейлистов; тогда ссылка на плейлист - ошибка.
+func PresignPlaylist(ctx context.Context, st storage.StorageStreamProvider, playlistPath string, expiry time.Duration, mapPlaylist PlaylistURIMapper) ([]byte, error) {
+	r, err := st.Download(ctx, playlistPath)
+	if err != nil {
+		return nil, fmt.Errorf("download playlist %s: %w", playlistPath, err)
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read playlist %s: %w", playlistPath, err)
+	}
+
+	dir := path.Dir(playlistPath)
+	return rewritePlaylist(data, func(uri string) (string, error) {
+		if !isRelativeURI(uri) {
+			return uri, nil
+		}
+		target := path.Join(dir, uri)
+		if isPlaylistURI(uri) {
+			if mapPlaylist == nil {
+				return "", fmt.Errorf("playlist %s links to playlist %s, mapPlaylist is required", playlistPath, target)
+			}
+			mapped, err := mapPlaylist(target)
+			if err != nil {
+				return "", fmt.Errorf("map playlist %s: %w", target, err)
+			}
+			return mapped, nil
+		}
+		signed, err := st.GetPresignedURL(ctx, target, expiry)
+		if err != nil {
+			return "", fmt.Errorf("presign %s: %w", target, err)
+		}
+		return signed, nil
+	})
+}
+
+// isPlaylistURI сообщает, что ссылка ведет на HLS-плейлист.
+func isPlaylistURI(uri string) bool {
+	uri, _, _ = strings.Cut(uri, "?")
+	return strings.HasSuffix(uri, ".m3u8")
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/storage"
+)
+
+func putObject(t *testing.T, st storage.StorageStreamProvider, objectPath, data string) {
+	t.Helper()
+	w, err := st.Upload(context.Background(), objectPath, storage.UploadOptions{Size: int64(len(data))})
+	if err != nil {
+		t.Fatalf("Upload(%s): %v", objectPath, err)
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatalf("Write(%s): %v", objectPath, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close(%s): %v", objectPath, err)
+	}
+}
+
+func TestPresignPlaylist(t *testing.T) {
+	st, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	putObject(t, st, "videos/hls/v1/master.m3u8",
+		"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n360p/stream_360p.m3u8\n")
+	putObject(t, st, "videos/hls/v1/360p/stream_360p.m3u8",
+		"#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:6.0,\nsegment_360p_0.ts\n#EXTINF:6.0,\nhttps://cdn.example.com/ad.ts\n")
+
+	ctx := context.Background()
+
+	t.Run("variant playlist", func(t *testing.T) {
+		got, err := PresignPlaylist(ctx, st, "videos/hls/v1/360p/stream_360p.m3u8", time.Hour, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"videos/hls/v1/360p/segment_360p_0.ts\n",
+			`URI="file://`,
+			"https://cdn.example.com/ad.ts\n",
+		} {
+			if !strings.Contains(string(got), want) {
+				t.Errorf("PresignPlaylist() = %q, want it to contain %q", got, want)
+			}
+		}
+		if strings.Contains(string(got), "\nsegment_360p_0.ts") {
+			t.Errorf("PresignPlaylist() left a relative segment link: %q", got)
+		}
+	})
+
+	t.Run("master playlist maps variants", func(t *testing.T) {
+		var mapped []string
+		got, err := PresignPlaylist(ctx, st, "videos/hls/v1/master.m3u8", time.Hour, func(objectPath string) (string, error) {
+			mapped = append(mapped, objectPath)
+			return "https://api.example.com/playlist?path=" + objectPath, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"videos/hls/v1/360p/stream_360p.m3u8"}; len(mapped) != 1 || mapped[0] != want[0] {
+			t.Errorf("mapPlaylist called with %q, want %q", mapped, want)
+		}
+		if want := "https://api.example.com/playlist?path=videos/hls/v1/360p/stream_360p.m3u8\n"; !strings.Contains(string(got), want) {
+			t.Errorf("PresignPlaylist() = %q, want it to contain %q", got, want)
+		}
+	})
+
+	t.Run("master playlist without mapper", func(t *testing.T) {
+		if _, err := PresignPlaylist(ctx, st, "videos/hls/v1/master.m3u8", time.Hour, nil); err == nil {
+			t.Fatal("PresignPlaylist() error = nil, want error for variant link without mapPlaylist")
+		}
+	})
+}
+
+func TestRelativeKey(t *testing.T) {
+	tests := []struct {
+		fromDir, target, want string
+	}{
+		{"hls/v/rev", "hls/v/rev/segment_720p_0.ts", "segment_720p_0.ts"},
+		{"hls/v", "hls/v/720p/stream_720p.m3u8", "720p/stream_720p.m3u8"},
+		{"hls/v/720p", "hls/v/720p/segment_720p_0.ts", "segment_720p_0.ts"},
+		{"hls/v/720p", "hls/v/master.m3u8", "../master.m3u8"},
+		{"hls/v/720p", "hls/v/480p/segment_480p_0.ts", "../480p/segment_480p_0.ts"},
+		{"a/b", "c/d/e", "../../c/d/e"},
+		{"a/b", "a/b", "../b"},
+		{"", "x/y", "x/y"},
+	}
+
+	for _, tt := range tests {
+		if got := relativeKey(tt.fromDir, tt.target); got != tt.want {
+			t.Errorf("relativeKey(%q, %q) = %q, want %q", tt.fromDir, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestRewritePlaylist(t *testing.T) {
+	rename := map[string]string{
+		"stream_720p.m3u8":  "720p/stream_720p.m3u8",
+		"segment_720p_0.ts": "720p/segment_720p_0.ts",
+		"init.mp4":          "720p/init.mp4",
+	}
+	rewrite := func(uri string) (string, error) {
+		if !isRelativeURI(uri) {
+			return uri, nil
+		}
+		if to, ok := rename[uri]; ok {
+			return to, nil
+		}
+		return uri, nil
+	}
+
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "master",
+			in:   "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=1280x720\nstream_720p.m3u8\n",
+			want: "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=1280x720\n720p/stream_720p.m3u8\n",
+		},
+		{
+			name: "variant with map, blank lines and absolute links",
+			in:   "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n\n#EXTINF:6.0,\nsegment_720p_0.ts\n#EXTINF:6.0,\nhttps://cdn.example.com/ad.ts\n#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/1\"\n",
+			want: "#EXTM3U\n#EXT-X-MAP:URI=\"720p/init.mp4\"\n\n#EXTINF:6.0,\n720p/segment_720p_0.ts\n#EXTINF:6.0,\nhttps://cdn.example.com/ad.ts\n#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/1\"\n",
+		},
+		{
+			name: "indented uri and missing trailing newline",
+			in:   "#EXTM3U\n  stream_720p.m3u8",
+			want: "#EXTM3U\n720p/stream_720p.m3u8\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewritePlaylist([]byte(tt.in), rewrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("rewritePlaylist() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		failure := errors.New("presign failed")
+		for _, in := range []string{"segment.ts\n", "#EXT-X-MAP:URI=\"init.mp4\"\n"} {
+			_, err := rewritePlaylist([]byte(in), func(string) (string, error) { return "", failure })
+			if !errors.Is(err, failure) {
+				t.Errorf("rewritePlaylist(%q) error = %v, want %v", in, err, failure)
+			}
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/storage"
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+	"github.com/google/uuid"
+)
+
+// RevisionPointer - содержимое объекта-указателя (OUTPUT_POINTER_TEMPLATE):
+// текущая ревизия результата видео и история ревизий, новые первыми.
+type RevisionPointer struct {
+	VideoID   uuid.UUID       `json:"video_id"`
+	Current   string          `json:"current"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Revisions []RevisionEntry `json:"revisions"`
+}
+
+type RevisionEntry struct {
+	Revision          string `json:"revision"`
+	MasterPlaylistKey string `json:"master_playlist_key"`
+	// Root - директория со всеми файлами ревизии; пусто, если ее нельзя удалить целиком.
+	Root      string    `json:"root,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newRevision возвращает идентификатор ревизии: время создания (чтобы ревизии
+// сортировались по имени) и начало processID (чтобы не совпадали при повторах).
+func newRevision(now time.Time, processID string) string {
+	if len(processID) > 8 {
+		processID = processID[:8]
+	}
+	return now.UTC().Format("20060102T150405Z") + "-" + processID
+}
+
+// publishRevision делает entry текущей ревизией видео: перезаписывает указатель
+// и, если задан KeepRevisions, удаляет ревизии сверх лимита.
+// Указатель пишется одним объектом после публикации всех файлов ревизии,
+// так что читатель указателя видит либо старую, либо новую ревизию целиком.
+// Параллельная обработка одного видео может потерять запись в истории;
+// такие ревизии остаются в хранилище, но текущая ревизия всегда целая.
+func (vs *VideoService) publishRevision(ctx context.Context, layout outputLayout, videoID uuid.UUID, entry RevisionEntry, logger *slog.Logger) error {
+	pointerPath := layout.pointerPath()
+
+	pointer, err := vs.readPointer(ctx, pointerPath)
+	if err != nil {
+		return err
+	}
+	pointer.VideoID = videoID
+	pointer.Current = entry.Revision
+	pointer.UpdatedAt = entry.CreatedAt
+	pointer.Revisions = append([]RevisionEntry{entry}, pointer.Revisions...)
+
+	var stale []RevisionEntry
+	if vs.cfg.KeepRevisions > 0 && len(pointer.Revisions) > vs.cfg.KeepRevisions {
+		stale = pointer.Revisions[vs.cfg.KeepRevisions:]
+		pointer.Revisions = pointer.Revisions[:vs.cfg.KeepRevisions]
+	}
+
+	if err := vs.writePointer(ctx, pointerPath, pointer); err != nil {
+		return err
+	}
+	logger.Info("Revision published", "pointer", pointerPath, "revision", entry.Revision)
+
+	// Указатель уже не ссылается на старые ревизии, ошибки удаления только логируем
+	for _, old := range stale {
+		if old.Root == "" {
+			logger.Warn("stale revision has no root, keeping it", "revision", old.Revision)
+			continue
+		}
+		if err := vs.storage.RemoveAll(ctx, layout.bucket+"/"+old.Root); err != nil {
+			logger.Error("failed to remove stale revision", "revision", old.Revision, "root", old.Root, "error", err)
+			continue
+		}
+		logger.Info("Stale revision removed", "revision", old.Revision, "root", old.Root)
+	}
+	return nil
+}
+
+// readPointer читает указатель; отсутствие указателя - первая ревизия.
+func (vs *VideoService) readPointer(ctx context.Context, pointerPath string) (RevisionPointer, error) {
+	var pointer RevisionPointer
+
+	r, err := vs.storage.Download(ctx, pointerPath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return pointer, nil
+	}
+	if err != nil {
+		return pointer, fmt.Errorf("read revision pointer %s: %w", pointerPath, err)
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if err := json.NewDecoder(r).Decode(&pointer); err != nil {
+		return pointer, fmt.Errorf("decode revision pointer %s: %w", pointerPath, err)
+	}
+	return pointer, nil
+}
+
+func (vs *VideoService) writePointer(ctx context.Context, pointerPath string, pointer RevisionPointer) error {
+	body, err := json.MarshalIndent(pointer, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode revision pointer: %w", err)
+	}
+
+	w, err := vs.storage.Upload(ctx, pointerPath, storage.UploadOptions{
+		Size:         int64(len(body)),
+		ContentType:  "application/json",
+		CacheControl: "no-cache",
+	})
+	if err != nil {
+		return fmt.Errorf("write revision pointer %s: %w", pointerPath, err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(body)); err != nil {
+		w.CloseWithError(err)
+		return fmt.Errorf("write revision pointer %s: %w", pointerPath, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("commit revision pointer %s: %w", pointerPath, err)
+	}
+	return nil
+}
+
+// newRevisionEntry описывает ревизию, только что выгруженную по layout.
+func newRevisionEntry(layout outputLayout, revision string, now time.Time) RevisionEntry {
+	return RevisionEntry{
+		Revision:          revision,
+		MasterPlaylistKey: layout.key(task.MastePLName),
+		Root:              layout.revisionRoot(),
+		CreatedAt:         now,
+	}
+}
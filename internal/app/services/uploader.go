@@ -0,0 +1,330 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/storage"
+	"github.com/VideoHosting-Platform/VideoProcessor/internal/app/task"
+)
+
+type uploadItem struct {
+	localPath   string
+	relPath     string // путь внутри директории результата, через "/"
+	stagingPath string // куда файл выгружается; совпадает с objectPath без staging
+	objectPath  string // где файл окажется после публикации
+	opts        storage.UploadOptions
+}
+
+// uploadAllFilesInDir выгружает результат обработки в хранилище по ключам из layout.
+//
+// Если включен staging, файлы сначала выгружаются под staging-префикс и только
+// после успешной выгрузки всех файлов копируются на итоговые ключи (публикация),
+// после чего staging удаляется. При любой ошибке (и при отмене) все уже
+// появившиеся объекты удаляются, так что в хранилище не остается битого результата
+// (кроме итоговых ключей без директории на ревизию, см. rollback).
+//
+// И выгрузка, и публикация идут в три этапа: сначала сегменты, затем вариантные
+// плейлисты и в конце мастер-плейлист, чтобы плеер никогда не увидел плейлист,
+// ссылающийся на еще не загруженные сегменты.
+func (vs *VideoService) uploadAllFilesInDir(ctx context.Context, sourceFolder string, layout outputLayout, logger *slog.Logger, progress task.ProgressFunc) error {
+	logger = logger.With(
+		"method", "uploadAllFilesInDir",
+		"sourceFolder", sourceFolder,
+	)
+
+	stages, err := vs.planUpload(ctx, sourceFolder, layout)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, stage := range stages {
+		total += len(stage)
+	}
+	steps := total
+	if layout.staging != "" {
+		steps *= 2 // выгрузка и публикация
+	}
+	logger.Debug("files to upload", "segments", len(stages[0]), "playlists", len(stages[1]), "total", total, "staging", layout.staging, "master", layout.objectPath(task.MastePLName))
+
+	onDone := newUploadProgress(steps, vs.cfg.ProgressInterval, progress).step
+
+	for _, stage := range stages {
+		err := vs.runParallel(ctx, stage, func(ctx context.Context, item uploadItem) error {
+			return vs.withRetry(ctx, logger, item.stagingPath, func() error {
+				return vs.uploadFile(ctx, item)
+			})
+		}, onDone)
+		if err != nil {
+			// Со staging итоговые ключи еще не тронуты: там может лежать прошлый результат
+			vs.rollback(ctx, layout, stages, layout.staging == "", logger)
+			return err
+		}
+	}
+
+	if layout.staging == "" {
+		return nil
+	}
+
+	for _, stage := range stages {
+		err := vs.runParallel(ctx, stage, func(ctx context.Context, item uploadItem) error {
+			return vs.withRetry(ctx, logger, item.objectPath, func() error {
+				return vs.storage.Copy(ctx, item.stagingPath, item.objectPath)
+			})
+		}, onDone)
+		if err != nil {
+			vs.rollback(ctx, layout, stages, true, logger)
+			return fmt.Errorf("promote staged output: %w", err)
+		}
+	}
+
+	// Результат уже опубликован, ошибка очистки staging его не портит.
+	if err := vs.storage.RemoveAll(ctx, layout.stagingRoot()); err != nil {
+		logger.Warn("failed to remove staged output", "staging", layout.stagingRoot(), "error", err)
+	}
+	logger.Debug("staged output promoted", "staging", layout.stagingRoot())
+
+	return nil
+}
+
+// uploadProgress считает выгруженные файлы и отдает процент в progress не чаще interval.
+// progress может публиковать событие в брокер и ждать подтверждения, поэтому
+// вызывается вне блокировки, чтобы не выстраивать потоки выгрузки в очередь.
+// 100% отправляет Execute после выгрузки.
+type uploadProgress struct {
+	steps    int
+	interval time.Duration
+	progress task.ProgressFunc
+
+	mu       sync.Mutex
+	done     int
+	lastSent time.Time
+}
+
+func newUploadProgress(steps int, interval time.Duration, progress task.ProgressFunc) *uploadProgress {
+	return &uploadProgress{steps: steps, interval: interval, progress: progress}
+}
+
+// step отмечает один завершенный файл.
+func (p *uploadProgress) step() {
+	p.mu.Lock()
+	p.done++
+	percent := float64(p.done) * 100 / float64(p.steps)
+	now := time.Now()
+	send := now.Sub(p.lastSent) >= p.interval
+	if send {
+		p.lastSent = now
+	}
+	p.mu.Unlock()
+
+	if send {
+		p.progress(task.Progress{Stage: task.StageUploading, Percent: percent})
+	}
+}
+
+// planUpload собирает файлы результата по этапам (сегменты, вариантные плейлисты,
+// мастер-плейлист) и переписывает ссылки в плейлистах так, чтобы относительные URI
+// вели на итоговые ключи файлов, а при PresignPlaylists - на presigned URL этих
+// объектов (вариантные плейлисты подписываются уже с подписанными сегментами,
+// так что цепочка работает из приватного бакета).
+func (vs *VideoService) planUpload(ctx context.Context, sourceFolder string, layout outputLayout) ([][]uploadItem, error) {
+	var segments, playlists, master []uploadItem
+	keys := make(map[string]string) // relPath -> ключ
+	err := filepath.WalkDir(sourceFolder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Пропускаем папки
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Относительный путь внутри localDir
+		relPath, err := filepath.Rel(sourceFolder, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		keys[relPath] = layout.key(relPath)
+
+		item := uploadItem{
+			localPath:   path,
+			relPath:     relPath,
+			stagingPath: layout.stagingPath(relPath),
+			objectPath:  layout.objectPath(relPath),
+			opts: storage.UploadOptions{
+				Size:        info.Size(),
+				ContentType: storage.ContentTypeByExt(relPath),
+			},
+		}
+
+		switch {
+		case relPath == task.MastePLName:
+			item.opts.CacheControl = vs.cfg.PlaylistCacheControl
+			master = append(master, item)
+		case strings.HasSuffix(relPath, ".m3u8"):
+			item.opts.CacheControl = vs.cfg.PlaylistCacheControl
+			playlists = append(playlists, item)
+		default:
+			item.opts.CacheControl = vs.cfg.SegmentCacheControl
+			segments = append(segments, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stage := range [][]uploadItem{playlists, master} {
+		for i := range stage {
+			item := &stage[i]
+			size, err := rewritePlaylistFile(item.localPath, func(uri string) (string, error) {
+				if !isRelativeURI(uri) {
+					return uri, nil
+				}
+				target, ok := keys[path.Join(path.Dir(item.relPath), uri)]
+				if !ok {
+					return uri, nil
+				}
+				if vs.cfg.PresignPlaylists {
+					return vs.storage.GetPresignedURL(ctx, layout.bucket+"/"+target, vs.cfg.PresignPlaylistExpiry)
+				}
+				return relativeKey(path.Dir(keys[item.relPath]), target), nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			item.opts.Size = size
+		}
+	}
+
+	return [][]uploadItem{segments, playlists, master}, nil
+}
+
+// rollback удаляет объекты результата: staging и, если published, итоговые объекты.
+// Итоговые объекты удаляются, только если у ревизии своя директория: иначе те же
+// ключи занимает прошлый опубликованный результат видео, и удаление стерло бы его.
+// ctx может быть уже отменен, поэтому удаляем без него; ошибки только логируются.
+func (vs *VideoService) rollback(ctx context.Context, layout outputLayout, stages [][]uploadItem, published bool, logger *slog.Logger) {
+	ctx = context.WithoutCancel(ctx)
+
+	if layout.staging != "" {
+		if err := vs.storage.RemoveAll(ctx, layout.stagingRoot()); err != nil {
+			logger.Error("failed to remove staged output", "staging", layout.stagingRoot(), "error", err)
+		}
+	}
+	if !published {
+		logger.Warn("staged output removed", "staging", layout.stagingRoot())
+		return
+	}
+	if layout.revisionRoot() == "" {
+		logger.Warn("output keys are shared with previous revisions, keeping partially published output",
+			"master", layout.objectPath(task.MastePLName), "template", layout.template)
+		return
+	}
+	// Мастер-плейлист удаляем первым, чтобы плеер не открыл результат без сегментов
+	for i := len(stages) - 1; i >= 0; i-- {
+		for _, item := range stages[i] {
+			if err := vs.storage.Remove(ctx, item.objectPath); err != nil {
+				logger.Error("failed to remove partial upload", "object", item.objectPath, "error", err)
+			}
+		}
+	}
+	logger.Warn("partial output removed", "master", layout.objectPath(task.MastePLName))
+}
+
+// runParallel выполняет fn для items не более чем в UploadWorkers потоков.
+// При первой ошибке оставшиеся операции отменяются.
+func (vs *VideoService) runParallel(ctx context.Context, items []uploadItem, fn func(context.Context, uploadItem) error, onDone func()) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	queue := make(chan uploadItem)
+	var wg sync.WaitGroup
+	for i := 0; i < min(vs.cfg.UploadWorkers, len(items)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				if err := fn(ctx, item); err != nil {
+					cancel(err)
+					continue
+				}
+				onDone()
+			}
+		}()
+	}
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
+
+// withRetry выполняет op до UploadAttempts раз с линейно растущей задержкой.
+func (vs *VideoService) withRetry(ctx context.Context, logger *slog.Logger, objectPath string, op func() error) error {
+	var err error
+	for attempt := 1; attempt <= vs.cfg.UploadAttempts; attempt++ {
+		if err = op(); err == nil {
+			logger.Debug("File uploaded successfully", "objectPath", objectPath)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		logger.Warn("upload attempt failed", "objectPath", objectPath, "attempt", attempt, "error", err)
+		if attempt < vs.cfg.UploadAttempts {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(vs.cfg.UploadRetryDelay * time.Duration(attempt)):
+			}
+		}
+	}
+	return err
+}
+
+// uploadFile выгружает один файл и возвращается только после того,
+// как объект сохранен в хранилище.
+func (vs *VideoService) uploadFile(ctx context.Context, item uploadItem) error {
+	file, err := os.Open(item.localPath)
+	if err != nil {
+		return fmt.Errorf("open file %s failed: %w", item.localPath, err)
+	}
+	defer file.Close()
+
+	writer, err := vs.storage.Upload(ctx, item.stagingPath, item.opts)
+	if err != nil {
+		return fmt.Errorf("upload %s failed: %w", item.localPath, err)
+	}
+	if _, err := io.Copy(writer, file); err != nil {
+		// Прерываем загрузку, чтобы в хранилище не остался обрезанный объект
+		writer.CloseWithError(err)
+		return fmt.Errorf("copy file %s to storage failed: %w", item.localPath, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("commit file %s to storage failed: %w", item.localPath, err)
+	}
+
+	return nil
+}
@@ -1,8 +1,9 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -15,22 +16,106 @@ import (
 
 // Временные константы
 const (
-	BUCKET_NAME = "videos"
-	EXPIRY_TIME = 1 * time.Hour // Время жизни presigned URL
+	EXPIRY_TIME = 1 * time.Hour // Время жизни presigned URL исходника
 )
 
+type Config struct {
+	// Исходное видео читается из <SourceBucket>/<SourcePrefix><video_id>.
+	// Пустой бакет - MINIO_BUCKET_NAME.
+	SourceBucket string `env:"SOURCE_BUCKET"`
+	SourcePrefix string `env:"SOURCE_PREFIX"`
+	// Результат выгружается в <OutputBucket>/<OutputPrefix><ключ>, ключ каждого файла
+	// строится по OutputKeyTemplate. Пустой бакет - MINIO_BUCKET_NAME.
+	// Префикс по умолчанию не дает директории результата "<video_id>/" совпасть
+	// с объектом исходника "<video_id>" в том же бакете.
+	OutputBucket string `env:"OUTPUT_BUCKET"`
+	OutputPrefix string `env:"OUTPUT_PREFIX" envDefault:"hls/"`
+	// OutputKeyTemplate - переменные {video_id}, {user_id}, {process_id},
+	// {revision} (ревизия результата: время обработки и начало process_id),
+	// {rendition} (имя варианта, например 720p; пусто для мастер-плейлиста) и {file} (имя файла).
+	OutputKeyTemplate string `env:"OUTPUT_KEY_TEMPLATE" envDefault:"{video_id}/{revision}/{file}"`
+	// OutputPointerTemplate - ключ объекта-указателя на текущую ревизию видео
+	// (RevisionPointer в JSON), переменные {video_id} и {user_id}. Пусто - без указателя.
+	OutputPointerTemplate string `env:"OUTPUT_POINTER_TEMPLATE" envDefault:"{video_id}/current.json"`
+	// KeepRevisions - сколько последних ревизий хранить, старые удаляются после
+	// публикации новой. 0 - хранить все.
+	KeepRevisions int `env:"OUTPUT_KEEP_REVISIONS" envDefault:"0"`
+
+	// PlaybackURLMode - что сохранять в каталоге как URL мастер-плейлиста:
+	//   presigned - presigned URL на PlaybackURLExpiry, ссылка в каталоге протухнет;
+	//   public    - PlaybackBaseURL + "/" + ключ (публичный бакет или CDN);
+	//   key       - только ключ объекта, URL строит API.
+	// По умолчанию presigned, как и раньше: key меняет содержимое поля для API.
+	PlaybackURLMode string `env:"PLAYBACK_URL_MODE" envDefault:"presigned"`
+	// PlaybackBaseURL - origin бакета результатов или CDN, например https://cdn.example.com/videos.
+	PlaybackBaseURL   string        `env:"PLAYBACK_BASE_URL"`
+	PlaybackURLExpiry time.Duration `env:"PLAYBACK_URL_EXPIRY" envDefault:"1h"`
+
+	// ProgressInterval - как часто отдавать прогресс выгрузки (та же настройка,
+	// что и для прогресса перекодирования).
+	ProgressInterval time.Duration `env:"PROCESS_PROGRESS_INTERVAL" envDefault:"5s"`
+
+	// UploadTimeout - запас времени на выгрузку, публикацию и указатель ревизии сверх
+	// лимита перекодирования: вместе они ограничивают всю задачу (TranscodeTimeout + UploadTimeout).
+	UploadTimeout time.Duration `env:"UPLOAD_TIMEOUT" envDefault:"30m"`
+	// UploadWorkers - сколько файлов выгружается параллельно.
+	UploadWorkers int `env:"UPLOAD_WORKERS" envDefault:"4"`
+	// UploadAttempts - сколько раз пытаться выгрузить один файл.
+	UploadAttempts int `env:"UPLOAD_ATTEMPTS" envDefault:"3"`
+	// UploadRetryDelay - задержка перед повтором, растет линейно с номером попытки.
+	UploadRetryDelay time.Duration `env:"UPLOAD_RETRY_DELAY" envDefault:"1s"`
+
+	// Cache-Control для выгружаемых объектов: сегменты не меняются и кэшируются надолго,
+	// плейлисты - коротко.
+	SegmentCacheControl  string `env:"UPLOAD_SEGMENT_CACHE_CONTROL" envDefault:"public, max-age=31536000, immutable"`
+	PlaylistCacheControl string `env:"UPLOAD_PLAYLIST_CACHE_CONTROL" envDefault:"public, max-age=60"`
+
+	// PresignPlaylists - переписывать выгружаемые плейлисты так, чтобы все ссылки были
+	// абсолютными presigned URL: воспроизведение из приватного бакета без участия API.
+	// Ссылки перестают работать через PresignPlaylistExpiry (для S3 не больше 7 дней).
+	PresignPlaylists      bool          `env:"UPLOAD_PRESIGN_PLAYLISTS" envDefault:"false"`
+	PresignPlaylistExpiry time.Duration `env:"UPLOAD_PRESIGN_EXPIRY" envDefault:"168h"`
+
+	// StagingPrefix - префикс в бакете результата, под который файлы выгружаются
+	// до публикации (<StagingPrefix><process_id>/<ключ>). Пусто - выгрузка сразу
+	// на итоговые ключи; частичный результат при ошибке удаляется в обоих случаях.
+	// Если в OutputKeyTemplate нет директории {revision}/ или {process_id}/, итоговые
+	// ключи общие с прошлым результатом и при ошибке публикации не удаляются.
+	StagingPrefix string `env:"UPLOAD_STAGING_PREFIX" envDefault:".staging/"`
+}
+
 type VideoService struct {
 	storage storage.StorageStreamProvider
 	task.Processer
+	cfg Config
 }
 
-func NewVideoService(st storage.StorageStreamProvider, p task.Processer) *VideoService {
-	return &VideoService{storage: st, Processer: p}
+func NewVideoService(st storage.StorageStreamProvider, p task.Processer, cfg Config) *VideoService {
+	if cfg.UploadWorkers < 1 {
+		cfg.UploadWorkers = 1
+	}
+	if cfg.UploadAttempts < 1 {
+		cfg.UploadAttempts = 1
+	}
+	if cfg.PlaybackURLMode == PlaybackURLPresigned {
+		slog.Warn("catalog playback URLs will expire, use PLAYBACK_URL_MODE=key or public for permanent links",
+			"expiry", cfg.PlaybackURLExpiry)
+	}
+	return &VideoService{storage: st, Processer: p, cfg: cfg}
 
 }
 
 // Сервис выполняет 3 функции, загрузки, обработки, выгрузки видео.
-func (vs *VideoService) Execute(vt task.VideoTask) (string, error) {
+// Отмена ctx прерывает обработку, временные файлы при этом удаляются.
+// Вся задача ограничена по времени: лимит перекодирования по длительности исходника
+// плюс UploadTimeout; по истечении ошибка получает класс task.ErrorClassTimeout.
+// progress получает обновления по этапам обработки.
+// Ошибки помечаются классом (task.JobError) для событий жизненного цикла.
+func (vs *VideoService) Execute(ctx context.Context, vt task.VideoTask, progress task.ProgressFunc) (task.Output, error) {
+	if progress == nil {
+		progress = func(task.Progress) {}
+	}
+
 	processID := uuid.New().String()
 	logger := slog.Default().With(
 		"component", "VideoService",
@@ -41,10 +126,16 @@ func (vs *VideoService) Execute(vt task.VideoTask) (string, error) {
 
 	logger.Info("Starting video processing task")
 
+	// До ffprobe длительность неизвестна: ограничиваем задачу максимальным лимитом
+	// и сужаем его, когда длительность станет известна.
+	start := time.Now()
+	ctx, cancel := vs.withJobDeadline(ctx, start, 0)
+	defer cancel()
+
 	taskTempDir, err := os.MkdirTemp("", "video-process-")
 
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp dir for task %s: %w", vt.VideoID, err)
+		return task.Output{}, fmt.Errorf("failed to create temp dir for task %s: %w", vt.VideoID, err)
 	}
 
 	logger.Debug("Created temporary directory for task", "tempDir", taskTempDir)
@@ -55,92 +146,94 @@ func (vs *VideoService) Execute(vt task.VideoTask) (string, error) {
 	localOutputPath := filepath.Join(taskTempDir, "processed_output") // processed dir
 
 	if err := os.MkdirAll(localOutputPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create output temp subdir for task %s: %w", vt.VideoID, err)
+		return task.Output{}, fmt.Errorf("failed to create output temp subdir for task %s: %w", vt.VideoID, err)
 	}
 
 	// Загрузка
 
-	downloadPath := filepath.Join(BUCKET_NAME, vt.VideoID.String())
-	url, err := vs.storage.GetPresignedURL(downloadPath, EXPIRY_TIME)
+	downloadPath := vs.sourcePath(vt)
+	url, err := vs.storage.GetPresignedURL(ctx, downloadPath, EXPIRY_TIME)
 	if err != nil {
-		return "", fmt.Errorf("failed to get presigned URL for %s: %w", downloadPath, err)
+		return task.Output{}, jobError(ctx, task.ErrorClassStorage, fmt.Errorf("failed to get presigned URL for %s: %w", downloadPath, err))
 	}
 
 	logger.Info("Presigned URL for download", "download_path", downloadPath)
 
+	meta, err := vs.Probe(ctx, url)
+	if err != nil {
+		return task.Output{}, jobError(ctx, task.ErrorClassTranscode, fmt.Errorf("failed to probe video %s: %w", vt.VideoID, err))
+	}
+	ctx, cancel = vs.withJobDeadline(ctx, start, meta.Duration)
+	defer cancel()
+
 	//Обработка
-	err = vs.Process(vt, url, localOutputPath)
+	progress(task.Progress{Stage: task.StageTranscoding})
+	err = vs.Process(ctx, vt, url, localOutputPath, meta, progress)
 	if err != nil {
-		return "", fmt.Errorf("failed to process video %s: %w", vt.VideoID, err)
+		return task.Output{}, jobError(ctx, task.ErrorClassTranscode, fmt.Errorf("failed to process video %s: %w", vt.VideoID, err))
 	}
 
 	//Выгрузка
 
-	uploadPrefix := fmt.Sprintf("%s/%s", BUCKET_NAME, processID)
+	now := time.Now()
+	revision := newRevision(now, processID)
+	layout := vs.newOutputLayout(vt, processID, revision)
+	masterPath := layout.objectPath(task.MastePLName)
 
 	// 2) Рекурсивно ходим по локальной папке LOCAL_DIR
-	logger.Info("Uploading processed files", "localOutputPath", localOutputPath, "uploadPrefix", uploadPrefix)
-	err = vs.uploadAllFilesInDir(localOutputPath, uploadPrefix, logger)
+	logger.Info("Uploading processed files", "localOutputPath", localOutputPath, "masterPath", masterPath)
+	progress(task.Progress{Stage: task.StageUploading})
+	err = vs.uploadAllFilesInDir(ctx, localOutputPath, layout, logger, progress)
+	if err != nil && errors.Is(context.Cause(ctx), task.ErrCancelled) {
+		// Частично выгруженный результат уже удален
+		return task.Output{}, fmt.Errorf("upload to %s cancelled: %w", masterPath, context.Cause(ctx))
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to upload files from %s to %s: %w", localOutputPath, uploadPrefix, err)
+		return task.Output{}, jobError(ctx, task.ErrorClassStorage, fmt.Errorf("failed to upload files from %s (master %s): %w", localOutputPath, masterPath, err))
+	}
+	progress(task.Progress{Stage: task.StageUploading, Percent: 100})
+
+	logger.Info("All files uploaded successfully", "masterPath", masterPath, "revision", revision)
+
+	if layout.pointerPath() != "" {
+		entry := newRevisionEntry(layout, revision, now)
+		if err := vs.publishRevision(ctx, layout, vt.VideoID, entry, logger); err != nil {
+			// Без указателя ревизия никому не видна, повтор задачи создаст новую
+			if entry.Root != "" {
+				if rmErr := vs.storage.RemoveAll(context.WithoutCancel(ctx), layout.bucket+"/"+entry.Root); rmErr != nil {
+					logger.Error("failed to remove unpublished revision", "root", entry.Root, "error", rmErr)
+				}
+			}
+			return task.Output{}, jobError(ctx, task.ErrorClassStorage, fmt.Errorf("failed to publish revision %s: %w", revision, err))
+		}
 	}
 
-	logger.Info("All files uploaded successfully", "uploadPrefix", uploadPrefix)
-
-	// Если нужно возвращать URL, то можно сделать presigned URL для папки
-	url, err = vs.storage.GetPresignedURL(uploadPrefix+"/"+task.MastePLName, EXPIRY_TIME)
+	out := task.Output{
+		Bucket:   layout.bucket,
+		Key:      layout.key(task.MastePLName),
+		Revision: revision,
+	}
+	out.URL, err = vs.playbackURL(ctx, out.Bucket, out.Key)
 	if err != nil {
-		return "", fmt.Errorf("failed to get presigned URL(in Execute) for %s: %w", uploadPrefix, err)
+		return task.Output{}, jobError(ctx, task.ErrorClassStorage, fmt.Errorf("failed to get playback URL(in Execute) for %s: %w", masterPath, err))
 	}
-	return url, nil
+	return out, nil
 
 }
 
-func (vs *VideoService) uploadAllFilesInDir(sourceFolder string, remoteFolderPrefix string, logger *slog.Logger) error {
-	logger = logger.With(
-		"method", "uploadAllFilesInDir",
-		"sourceFolder", sourceFolder,
-		"remoteFolderPrefix", remoteFolderPrefix,
-	)
-	err := filepath.Walk(sourceFolder, func(path string, info os.FileInfo, err error) error {
-		logger.Debug("uploading file", "path", path, "info", info)
-		if err != nil {
-			return err
-		}
-		// Пропускаем папки
-		if info.IsDir() {
-			return nil
-		}
-		// Относительный путь внутри localDir
-		relPath, err := filepath.Rel(sourceFolder, path)
-		if err != nil {
-			return err
-		}
-		// Собираем путь в бакете: "<bucket>/<processID>/<relPath>"
-		objectPath := filepath.ToSlash(filepath.Join(remoteFolderPrefix, relPath))
-		logger.Debug("uploading file to storage", "objectPath", objectPath)
-
-		writer, err := vs.storage.Upload(objectPath)
-		if err != nil {
-			return fmt.Errorf("upload %s failed: %w", path, err)
-		}
-		defer writer.Close()
-		file, err := os.Open(path)
-		if err != nil {
-			return fmt.Errorf("open file %s failed: %w", path, err)
-		}
-		defer file.Close()
-		if _, err := io.Copy(writer, file); err != nil {
-			return fmt.Errorf("copy file %s to storage failed: %w", path, err)
-		}
-
-		logger.Debug("File uploaded successfully", "objectPath", objectPath)
+// withJobDeadline ограничивает задачу, начатую в start, лимитом перекодирования
+// исходника длительностью duration (0 - неизвестна, максимальный лимит) плюс UploadTimeout.
+func (vs *VideoService) withJobDeadline(ctx context.Context, start time.Time, duration time.Duration) (context.Context, context.CancelFunc) {
+	timeout := vs.TranscodeTimeout(duration) + vs.cfg.UploadTimeout
+	return context.WithDeadlineCause(ctx, start.Add(timeout),
+		task.NewJobError(task.ErrorClassTimeout, fmt.Errorf("job exceeded %s", timeout)))
+}
 
-		return nil
-	})
-	if err != nil {
-		return err
+// jobError помечает ошибку этапа классом class. Если задача не уложилась
+// в лимит времени, ошибка оборачивает причину отмены ctx, и ее класс - ErrorClassTimeout.
+func jobError(ctx context.Context, class task.ErrorClass, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", context.Cause(ctx), err)
 	}
-
-	return nil
+	return task.NewJobError(class, err)
 }
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	BackendMinio = "minio"
+	BackendLocal = "local"
+)
+
+type Config struct {
+	// Backend - реализация хранилища: minio или local.
+	Backend string `env:"STORAGE_BACKEND" envDefault:"minio"`
+	// LocalDir - корневая директория для backend local.
+	LocalDir string `env:"STORAGE_LOCAL_DIR" envDefault:"./data"`
+
+	MinIO MinioConfig `envDefault:""`
+}
+
+// New создает хранилище, выбранное в конфигурации.
+func New(ctx context.Context, cfg Config) (StorageStreamProvider, error) {
+	switch cfg.Backend {
+	case BackendMinio:
+		return NewMinioStorage(ctx, cfg.MinIO)
+	case BackendLocal:
+		return NewLocalStorage(cfg.LocalDir)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %q", cfg.Backend)
+	}
+}
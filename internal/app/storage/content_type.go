@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"path"
+	"strings"
+)
+
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/mp4", // фрагменты fMP4
+	".mp4":  "video/mp4",
+	".vtt":  "text/vtt",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".json": "application/json",
+}
+
+// ContentTypeByExt возвращает Content-Type объекта по расширению его имени.
+func ContentTypeByExt(name string) string {
+	if ct, ok := contentTypes[strings.ToLower(path.Ext(name))]; ok {
+		return ct
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStorage хранит объекты в локальной директории: путь "<bucket>/<object>"
+// соответствует файлу <root>/<bucket>/<object>. Нужен для локальной разработки
+// и тестов без MinIO; вместо presigned URL отдает file:// URL, которые читает ffmpeg.
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolve local storage dir %s: %w", root, err)
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("create local storage dir %s: %w", abs, err)
+	}
+	return &LocalStorage{root: abs}, nil
+}
+
+// resolve переводит путь объекта в путь файла, не давая выйти за пределы root
+// (и указать на сам root, который RemoveAll удалил бы целиком).
+func (ls *LocalStorage) resolve(objectPath string) (string, error) {
+	if !strings.Contains(strings.Trim(objectPath, "/"), "/") {
+		return "", fmt.Errorf("error path object in local storage: %s", objectPath)
+	}
+	full := filepath.Join(ls.root, filepath.FromSlash(objectPath))
+	if !strings.HasPrefix(full, ls.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("object path %s escapes local storage root", objectPath)
+	}
+	return full, nil
+}
+
+// Upload пишет объект во временный файл рядом с целевым и переименовывает
+// его при Close, так что читатели никогда не видят недописанный объект.
+func (ls *LocalStorage) Upload(ctx context.Context, pathUpload string, opts UploadOptions) (ObjectWriter, error) {
+	full, err := ls.resolve(pathUpload)
+	if err != nil {
+		return nil, fmt.Errorf("upload to local storage failed (parsing path): %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return nil, fmt.Errorf("upload to local storage failed (creating dir): %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(full), "."+filepath.Base(full)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("upload to local storage failed (creating file): %w", err)
+	}
+
+	return &localObjectWriter{ctx: ctx, f: f, path: full}, nil
+}
+
+func (ls *LocalStorage) Download(ctx context.Context, pathDownload string) (io.Reader, error) {
+	full, err := ls.resolve(pathDownload)
+	if err != nil {
+		return nil, fmt.Errorf("download from local storage failed (parsing path): %w", err)
+	}
+
+	f, err := os.Open(full)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("download from local storage failed (%s): %w", pathDownload, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("download from local storage failed (opening file): %w", err)
+	}
+	return f, nil
+}
+
+// GetPresignedURL возвращает file:// URL объекта; expiry игнорируется.
+// Путь не экранируется: протокол file в ffmpeg не декодирует %-последовательности.
+func (ls *LocalStorage) GetPresignedURL(ctx context.Context, pathDownload string, expiry time.Duration) (string, error) {
+	full, err := ls.resolve(pathDownload)
+	if err != nil {
+		return "", fmt.Errorf("get URL from local storage failed (parsing path): %w", err)
+	}
+	return "file://" + filepath.ToSlash(full), nil
+}
+
+// Copy копирует файл объекта; как и Upload, копия появляется атомарно.
+func (ls *LocalStorage) Copy(ctx context.Context, pathSrc, pathDst string) error {
+	r, err := ls.Download(ctx, pathSrc)
+	if err != nil {
+		return fmt.Errorf("copy in local storage failed: %w", err)
+	}
+	defer r.(io.Closer).Close()
+
+	w, err := ls.Upload(ctx, pathDst, UploadOptions{})
+	if err != nil {
+		return fmt.Errorf("copy in local storage failed: %w", err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.CloseWithError(err)
+		return fmt.Errorf("copy in local storage failed (%s -> %s): %w", pathSrc, pathDst, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("copy in local storage failed (%s -> %s): %w", pathSrc, pathDst, err)
+	}
+	return nil
+}
+
+func (ls *LocalStorage) Remove(ctx context.Context, path string) error {
+	full, err := ls.resolve(path)
+	if err != nil {
+		return fmt.Errorf("remove from local storage failed (parsing path): %w", err)
+	}
+	if err := os.Remove(full); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove from local storage failed: %w", err)
+	}
+	return nil
+}
+
+func (ls *LocalStorage) RemoveAll(ctx context.Context, pathPrefix string) error {
+	full, err := ls.resolve(pathPrefix)
+	if err != nil {
+		return fmt.Errorf("remove from local storage failed (parsing path): %w", err)
+	}
+	if err := os.RemoveAll(full); err != nil {
+		return fmt.Errorf("remove from local storage failed: %w", err)
+	}
+	slog.Debug("Объекты удалены из локального хранилища", "path", full)
+	return nil
+}
+
+type localObjectWriter struct {
+	ctx  context.Context
+	f    *os.File
+	path string
+
+	closed bool
+	err    error
+}
+
+func (w *localObjectWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.f.Write(p)
+}
+
+func (w *localObjectWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+func (w *localObjectWriter) CloseWithError(cause error) error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+
+	if cause == nil {
+		cause = w.ctx.Err()
+	}
+	closeErr := w.f.Close()
+	if cause != nil || closeErr != nil {
+		os.Remove(w.f.Name())
+		w.err = errors.Join(cause, closeErr)
+		return w.err
+	}
+
+	if err := os.Rename(w.f.Name(), w.path); err != nil {
+		os.Remove(w.f.Name())
+		w.err = fmt.Errorf("upload to local storage failed (committing file): %w", err)
+	}
+	return w.err
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLocalStorage(t *testing.T) (*LocalStorage, string) {
+	t.Helper()
+	root := t.TempDir()
+	ls, err := NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ls, root
+}
+
+func readLocal(t *testing.T, ls *LocalStorage, objectPath string) (string, error) {
+	t.Helper()
+	r, err := ls.Download(context.Background(), objectPath)
+	if err != nil {
+		return "", err
+	}
+	defer r.(io.Closer).Close()
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+// dirEntries возвращает имена файлов в директории объекта, включая временные.
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestLocalStorageUploadCommitsOnClose(t *testing.T) {
+	ls, root := newLocalStorage(t)
+	ctx := context.Background()
+	const objectPath = "videos/hls/v1/segment_360p_0.ts"
+
+	w, err := ls.Upload(ctx, objectPath, UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "segment "); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readLocal(t, ls, objectPath); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Download before Close error = %v, want ErrNotFound", err)
+	}
+	if _, err := io.WriteString(w, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close = %v, want nil", err)
+	}
+
+	if got, err := readLocal(t, ls, objectPath); err != nil || got != "segment data" {
+		t.Fatalf("Download = %q, %v; want %q", got, err, "segment data")
+	}
+	if names := dirEntries(t, filepath.Join(root, "videos", "hls", "v1")); len(names) != 1 {
+		t.Errorf("object dir = %v, want only the committed object", names)
+	}
+}
+
+func TestLocalStorageUploadAbort(t *testing.T) {
+	failure := errors.New("source read failed")
+	tests := []struct {
+		name    string
+		abort   func(w ObjectWriter, cancel context.CancelFunc) error
+		wantErr error
+	}{
+		{
+			name:    "CloseWithError",
+			abort:   func(w ObjectWriter, cancel context.CancelFunc) error { return w.CloseWithError(failure) },
+			wantErr: failure,
+		},
+		{
+			name: "cancelled context",
+			abort: func(w ObjectWriter, cancel context.CancelFunc) error {
+				cancel()
+				return w.Close()
+			},
+			wantErr: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ls, root := newLocalStorage(t)
+			const objectPath = "videos/hls/v1/master.m3u8"
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Прежняя версия объекта остается, пока новая не закоммичена
+			w, err := ls.Upload(ctx, objectPath, UploadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, "old")
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			w, err = ls.Upload(ctx, objectPath, UploadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, "partial")
+			if err := tt.abort(w, cancel); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("abort error = %v, want %v", err, tt.wantErr)
+			}
+			if err := w.Close(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Close after abort = %v, want %v", err, tt.wantErr)
+			}
+
+			if got, err := readLocal(t, ls, objectPath); err != nil || got != "old" {
+				t.Errorf("Download = %q, %v; want the previous object", got, err)
+			}
+			if names := dirEntries(t, filepath.Join(root, "videos", "hls", "v1")); len(names) != 1 {
+				t.Errorf("object dir = %v, want the temp file removed", names)
+			}
+		})
+	}
+}
+
+func TestLocalStorageResolve(t *testing.T) {
+	ls, root := newLocalStorage(t)
+	tests := []struct {
+		objectPath string
+		want       string // пусто - ошибка
+	}{
+		{"videos/hls/v1/master.m3u8", filepath.Join(root, "videos", "hls", "v1", "master.m3u8")},
+		{"/videos/v1/", filepath.Join(root, "videos", "v1")},
+		{"videos/hls/../v1", filepath.Join(root, "videos", "v1")},
+		{"videos", ""},
+		{"videos/", ""},
+		{"videos/..", ""},
+		{"videos/../..", ""},
+		{"videos/../../etc/passwd", ""},
+		{"../outside/file", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := ls.resolve(tt.objectPath)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("resolve(%q) = %q, want error", tt.objectPath, got)
+		case tt.want != "" && (err != nil || got != tt.want):
+			t.Errorf("resolve(%q) = %q, %v; want %q", tt.objectPath, got, err, tt.want)
+		}
+	}
+
+	if err := ls.RemoveAll(context.Background(), "videos/.."); err == nil {
+		t.Error("RemoveAll(videos/..) = nil, want error")
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("storage root: %v", err)
+	}
+}
+
+func TestLocalStorageObjects(t *testing.T) {
+	ls, root := newLocalStorage(t)
+	ctx := context.Background()
+
+	put := func(objectPath, data string) {
+		t.Helper()
+		w, err := ls.Upload(ctx, objectPath, UploadOptions{Size: int64(len(data))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("videos/src/v1", "source")
+	put("videos/hls/v1/a.ts", "a")
+	put("videos/hls/v1/360p/b.ts", "b")
+
+	if _, err := readLocal(t, ls, "videos/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Download(missing) error = %v, want ErrNotFound", err)
+	}
+
+	url, err := ls.GetPresignedURL(ctx, "videos/src/v1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "file://" + filepath.ToSlash(filepath.Join(root, "videos", "src", "v1")); url != want {
+		t.Errorf("GetPresignedURL = %q, want %q", url, want)
+	}
+
+	if err := ls.Copy(ctx, "videos/src/v1", "videos/copy/v1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readLocal(t, ls, "videos/copy/v1"); err != nil || got != "source" {
+		t.Errorf("copied object = %q, %v; want %q", got, err, "source")
+	}
+	if err := ls.Copy(ctx, "videos/missing", "videos/copy/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Copy(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if err := ls.Remove(ctx, "videos/src/v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.Remove(ctx, "videos/src/v1"); err != nil {
+		t.Errorf("Remove(missing) = %v, want nil", err)
+	}
+	if _, err := readLocal(t, ls, "videos/src/v1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Download after Remove error = %v, want ErrNotFound", err)
+	}
+
+	if err := ls.RemoveAll(ctx, "videos/hls/v1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, objectPath := range []string{"videos/hls/v1/a.ts", "videos/hls/v1/360p/b.ts"} {
+		if _, err := readLocal(t, ls, objectPath); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Download(%s) after RemoveAll error = %v, want ErrNotFound", objectPath, err)
+		}
+	}
+	if got, err := readLocal(t, ls, "videos/copy/v1"); err != nil || !strings.HasPrefix(got, "source") {
+		t.Errorf("RemoveAll touched another prefix: %q, %v", got, err)
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,26 +12,152 @@ import (
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 )
 
+// MinioConfig - настройки S3-совместимого хранилища (MinIO, AWS S3 и т.п.).
+// Обязательные поля проверяются в NewMinioStorage, а не при загрузке
+// конфигурации: при STORAGE_BACKEND=local они не нужны.
 type MinioConfig struct {
-	Host       string `env:"MINIO_HOST,required"`
-	Port       string `env:"MINIO_PORT,required"`
-	BucketName string `env:"MINIO_BUCKET_NAME" envDefault:"my-bucket"`
-	AccessKey  string `env:"MINIO_ACCESS_KEY,required"`
-	SecretKey  string `env:"MINIO_SECRET_KEY,required"`
+	Host string `env:"MINIO_HOST"`
+	// Port можно не указывать, если endpoint на стандартном порту (например, s3.amazonaws.com).
+	Port string `env:"MINIO_PORT"`
+	// BucketName - бакет по умолчанию для исходников и результатов (см. SOURCE_BUCKET и OUTPUT_BUCKET).
+	BucketName string `env:"MINIO_BUCKET_NAME" envDefault:"videos"`
 	Secure     bool   `env:"MINIO_SECURE" envDefault:"false"`
+	// Region - регион бакетов; пусто - определяется запросом к хранилищу.
+	Region string `env:"MINIO_REGION"`
+	// BucketLookup - адресация бакета: auto, dns (virtual-host) или path.
+	BucketLookup string `env:"MINIO_BUCKET_LOOKUP" envDefault:"auto"`
+
+	// Credentials - откуда брать ключи:
+	//   static - MINIO_ACCESS_KEY/MINIO_SECRET_KEY (и MINIO_SESSION_TOKEN);
+	//   env    - стандартные переменные AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY/AWS_SESSION_TOKEN
+	//            или MINIO_ROOT_USER/MINIO_ROOT_PASSWORD;
+	//   iam    - роль EC2/ECS или web identity (AWS_WEB_IDENTITY_TOKEN_FILE и AWS_ROLE_ARN, IRSA в EKS).
+	Credentials  string `env:"MINIO_CREDENTIALS" envDefault:"static"`
+	AccessKey    string `env:"MINIO_ACCESS_KEY"`
+	SecretKey    string `env:"MINIO_SECRET_KEY"`
+	SessionToken string `env:"MINIO_SESSION_TOKEN"`
+
+	// SSE - шифрование загружаемых объектов на стороне сервера: пусто, sse-s3 или sse-kms.
+	SSE string `env:"MINIO_SSE"`
+	// SSEKMSKeyID - ключ KMS для sse-kms; пусто - ключ бакета по умолчанию.
+	SSEKMSKeyID string `env:"MINIO_SSE_KMS_KEY_ID"`
+}
+
+// Значения MinioConfig.Credentials.
+const (
+	CredentialsStatic = "static"
+	CredentialsEnv    = "env"
+	CredentialsIAM    = "iam"
+)
+
+// Значения MinioConfig.SSE.
+const (
+	SSES3  = "sse-s3"
+	SSEKMS = "sse-kms"
+)
+
+func (cfg MinioConfig) validate() error {
+	if cfg.Host == "" {
+		return fmt.Errorf("required variable MINIO_HOST is not set")
+	}
+	if cfg.Credentials != CredentialsStatic {
+		return nil
+	}
+
+	required := []struct{ name, value string }{
+		{"MINIO_ACCESS_KEY", cfg.AccessKey},
+		{"MINIO_SECRET_KEY", cfg.SecretKey},
+	}
+	for _, v := range required {
+		if v.value == "" {
+			return fmt.Errorf("required variable %s is not set", v.name)
+		}
+	}
+	return nil
+}
+
+func (cfg MinioConfig) endpoint() string {
+	if cfg.Port == "" {
+		return cfg.Host
+	}
+	return cfg.Host + ":" + cfg.Port
+}
+
+func (cfg MinioConfig) credentials() (*credentials.Credentials, error) {
+	switch cfg.Credentials {
+	case CredentialsStatic:
+		return credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, cfg.SessionToken), nil
+	case CredentialsEnv:
+		return credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+		}), nil
+	case CredentialsIAM:
+		// Пустой endpoint: IAM сам выбирает web identity, ECS или EC2 metadata.
+		return credentials.NewIAM(""), nil
+	default:
+		return nil, fmt.Errorf("unknown MINIO_CREDENTIALS %q (want %s, %s or %s)", cfg.Credentials, CredentialsStatic, CredentialsEnv, CredentialsIAM)
+	}
+}
+
+func (cfg MinioConfig) bucketLookup() (minio.BucketLookupType, error) {
+	switch cfg.BucketLookup {
+	case "", "auto":
+		return minio.BucketLookupAuto, nil
+	case "dns":
+		return minio.BucketLookupDNS, nil
+	case "path":
+		return minio.BucketLookupPath, nil
+	default:
+		return minio.BucketLookupAuto, fmt.Errorf("unknown MINIO_BUCKET_LOOKUP %q (want auto, dns or path)", cfg.BucketLookup)
+	}
+}
+
+func (cfg MinioConfig) serverSideEncryption() (encrypt.ServerSide, error) {
+	switch cfg.SSE {
+	case "":
+		return nil, nil
+	case SSES3:
+		return encrypt.NewSSE(), nil
+	case SSEKMS:
+		sse, err := encrypt.NewSSEKMS(cfg.SSEKMSKeyID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("SSE-KMS: %w", err)
+		}
+		return sse, nil
+	default:
+		return nil, fmt.Errorf("unknown MINIO_SSE %q (want %s or %s)", cfg.SSE, SSES3, SSEKMS)
+	}
 }
 
 type MinioStorage struct {
 	client *minio.Client
 	bucket string
+	region string
+	sse    encrypt.ServerSide // nil - без шифрования
 }
 
 func newMinioClient(cfg MinioConfig) (*minio.Client, error) {
-	client, err := minio.New(cfg.Host+":"+cfg.Port, &minio.Options{
-		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
-		Secure: cfg.Secure,
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid MinIO config: %w", err)
+	}
+	creds, err := cfg.credentials()
+	if err != nil {
+		return nil, fmt.Errorf("invalid MinIO config: %w", err)
+	}
+	lookup, err := cfg.bucketLookup()
+	if err != nil {
+		return nil, fmt.Errorf("invalid MinIO config: %w", err)
+	}
+
+	client, err := minio.New(cfg.endpoint(), &minio.Options{
+		Creds:        creds,
+		Secure:       cfg.Secure,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("creating MinIO client: %w", err)
@@ -38,14 +165,20 @@ func newMinioClient(cfg MinioConfig) (*minio.Client, error) {
 	return client, nil
 }
 
-func NewMinioStorage(cfg MinioConfig) (*MinioStorage, error) {
+func NewMinioStorage(ctx context.Context, cfg MinioConfig) (*MinioStorage, error) {
 
 	client, err := newMinioClient(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("initializing client MinIO error: %w", err)
 	}
+	sse, err := cfg.serverSideEncryption()
+	if err != nil {
+		return nil, fmt.Errorf("invalid MinIO config: %w", err)
+	}
 
-	_, err = client.ListBuckets(context.Background())
+	// Проверяем доступ к своему бакету, а не ListBuckets: в S3 роли сервиса
+	// обычно не выдают s3:ListAllMyBuckets.
+	_, err = client.BucketExists(ctx, cfg.BucketName)
 	if err != nil {
 		return nil, fmt.Errorf("connect to MinIO error : %w", err)
 	}
@@ -53,12 +186,12 @@ func NewMinioStorage(cfg MinioConfig) (*MinioStorage, error) {
 	return &MinioStorage{
 		client: client,
 		bucket: cfg.BucketName,
+		region: cfg.Region,
+		sse:    sse,
 	}, nil
 }
 
-func (ms *MinioStorage) Upload(pathUpload string) (io.WriteCloser, error) {
-	ctx := context.Background()
-
+func (ms *MinioStorage) Upload(ctx context.Context, pathUpload string, opts UploadOptions) (ObjectWriter, error) {
 	bucket, objectName, err := ms.parsePath(pathUpload)
 
 	if err != nil {
@@ -69,28 +202,33 @@ func (ms *MinioStorage) Upload(pathUpload string) (io.WriteCloser, error) {
 		return nil, fmt.Errorf("upload to Minio failed (bucket check):: %w", err)
 	}
 
+	size := opts.Size
+	if size <= 0 {
+		size = -1 // Размер неизвестен, MinIO загрузит объект multipart'ом
+	}
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = ContentTypeByExt(objectName)
+	}
+
 	// Загружаем файл
-	pr, pw := io.Pipe()
-	go func() {
-		defer pr.Close()
+	return newPipeObjectWriter(func(r io.Reader) error {
 		slog.Debug("Начало загрузки видео в MinIO", "bucket", bucket, "objectName", objectName)
-		_, err = ms.client.PutObject(ctx, bucket, objectName, pr, -1, minio.PutObjectOptions{
-			ContentType: "video/mp4",
+		_, err := ms.client.PutObject(ctx, bucket, objectName, r, size, minio.PutObjectOptions{
+			ContentType:          contentType,
+			CacheControl:         opts.CacheControl,
+			ServerSideEncryption: ms.sse,
 		})
 		if err != nil {
-			// TODO: проверить ошибку на EOF?
 			slog.Error("Ошибка загрузки видео в MinIO", "bucket", bucket, "objectName", objectName, "error", err)
-			return
+			return fmt.Errorf("upload to Minio failed (put object %s/%s): %w", bucket, objectName, err)
 		}
 		slog.Debug("Видео успешно загружено в MinIO", "bucket", bucket, "objectName", objectName)
-	}()
-
-	return pw, nil
+		return nil
+	}), nil
 }
 
-func (ms *MinioStorage) Download(pathDownload string) (io.Reader, error) {
-	ctx := context.Background()
-
+func (ms *MinioStorage) Download(ctx context.Context, pathDownload string) (io.Reader, error) {
 	bucket, objectName, err := ms.parsePath(pathDownload)
 	if err != nil {
 		return nil, fmt.Errorf("download from Minio failed (parsing path): %w", err)
@@ -100,11 +238,19 @@ func (ms *MinioStorage) Download(pathDownload string) (io.Reader, error) {
 	if err != nil {
 		return nil, fmt.Errorf("download from Minio failed (getting object): %w", err)
 	}
+	// GetObject ленивый: без Stat отсутствие объекта всплыло бы только при чтении
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("download from Minio failed (%s/%s): %w", bucket, objectName, ErrNotFound)
+		}
+		return nil, fmt.Errorf("download from Minio failed (stat object): %w", err)
+	}
 
 	return obj, nil
 }
 
-func (ms *MinioStorage) GetPresignedURL(pathDownload string, expiry time.Duration) (string, error) {
+func (ms *MinioStorage) GetPresignedURL(ctx context.Context, pathDownload string, expiry time.Duration) (string, error) {
 	reqParams := make(url.Values)
 
 	bucket, objectName, err := ms.parsePath(pathDownload)
@@ -112,13 +258,82 @@ func (ms *MinioStorage) GetPresignedURL(pathDownload string, expiry time.Duratio
 		return "", fmt.Errorf("get presigned URL from Minio failed (parsing path): %w", err)
 	}
 
-	presignedURL, err := ms.client.PresignedGetObject(context.Background(), bucket, objectName, expiry, reqParams)
+	presignedURL, err := ms.client.PresignedGetObject(ctx, bucket, objectName, expiry, reqParams)
 	if err != nil {
 		return "", fmt.Errorf("failed to get object (presigned URL): %w", err)
 	}
 	return presignedURL.String(), nil
 }
 
+// Copy копирует объект на стороне сервера вместе с метаданными
+// (Content-Type, Cache-Control); копия шифруется так же, как загружаемые объекты.
+func (ms *MinioStorage) Copy(ctx context.Context, pathSrc, pathDst string) error {
+	srcBucket, srcObject, err := ms.parsePath(pathSrc)
+	if err != nil {
+		return fmt.Errorf("copy in Minio failed (parsing source path): %w", err)
+	}
+	dstBucket, dstObject, err := ms.parsePath(pathDst)
+	if err != nil {
+		return fmt.Errorf("copy in Minio failed (parsing destination path): %w", err)
+	}
+
+	_, err = ms.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: dstBucket, Object: dstObject, Encryption: ms.sse},
+		minio.CopySrcOptions{Bucket: srcBucket, Object: srcObject},
+	)
+	if err != nil {
+		return fmt.Errorf("copy in Minio failed (%s/%s -> %s/%s): %w", srcBucket, srcObject, dstBucket, dstObject, err)
+	}
+	return nil
+}
+
+func (ms *MinioStorage) Remove(ctx context.Context, path string) error {
+	bucket, objectName, err := ms.parsePath(path)
+	if err != nil {
+		return fmt.Errorf("remove from Minio failed (parsing path): %w", err)
+	}
+	if err := ms.client.RemoveObject(ctx, bucket, objectName, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("remove from Minio failed (object %s/%s): %w", bucket, objectName, err)
+	}
+	return nil
+}
+
+func (ms *MinioStorage) RemoveAll(ctx context.Context, pathPrefix string) error {
+	bucket, prefix, err := ms.parsePath(pathPrefix)
+	if err != nil {
+		return fmt.Errorf("remove from Minio failed (parsing path): %w", err)
+	}
+	// Чтобы "videos/abc" не задел "videos/abcd/..."
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+
+	var objects []minio.ObjectInfo
+	for obj := range ms.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("remove from Minio failed (listing objects): %w", obj.Err)
+		}
+		objects = append(objects, obj)
+	}
+
+	objectsCh := make(chan minio.ObjectInfo, len(objects))
+	for _, obj := range objects {
+		objectsCh <- obj
+	}
+	close(objectsCh)
+
+	// Канал ошибок читаем до конца: иначе горутина minio-go заблокируется
+	// на отправке следующей ошибки
+	var errs []error
+	for rErr := range ms.client.RemoveObjects(ctx, bucket, objectsCh, minio.RemoveObjectsOptions{}) {
+		errs = append(errs, fmt.Errorf("object %s: %w", rErr.ObjectName, rErr.Err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("remove from Minio failed (%d of %d objects): %w", len(errs), len(objects), errors.Join(errs...))
+	}
+
+	slog.Debug("Объекты удалены из MinIO", "bucket", bucket, "prefix", prefix, "count", len(objects))
+	return nil
+}
+
 func (ms *MinioStorage) parsePath(path string) (string, string, error) {
 	arr := strings.Split(path, "/")
 	if len(arr) < 2 {
@@ -135,7 +350,7 @@ func (ms *MinioStorage) createBucketIfNotExists(ctx context.Context, bucket stri
 		return fmt.Errorf("bucket authentication error: %w", err)
 	}
 	if !exists {
-		if err = ms.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+		if err = ms.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: ms.region}); err != nil {
 			return fmt.Errorf("bucket create error: %w", err)
 		}
 	}
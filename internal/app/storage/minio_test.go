@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMinioConfigRejectsUnknownValues(t *testing.T) {
+	base := MinioConfig{
+		Host:         "localhost",
+		Port:         "9000",
+		Credentials:  CredentialsStatic,
+		AccessKey:    "access",
+		SecretKey:    "secret",
+		BucketLookup: "auto",
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*MinioConfig)
+		check   func(MinioConfig) error
+		wantErr string // пусто - ошибки нет
+	}{
+		{name: "valid", check: MinioConfig.validate},
+		{name: "no host", modify: func(c *MinioConfig) { c.Host = "" }, check: MinioConfig.validate, wantErr: "MINIO_HOST"},
+		{name: "static without secret", modify: func(c *MinioConfig) { c.SecretKey = "" }, check: MinioConfig.validate, wantErr: "MINIO_SECRET_KEY"},
+		{
+			name:   "iam without keys",
+			modify: func(c *MinioConfig) { c.Credentials, c.AccessKey, c.SecretKey = CredentialsIAM, "", "" },
+			check:  MinioConfig.validate,
+		},
+		{
+			name:    "unknown credentials",
+			modify:  func(c *MinioConfig) { c.Credentials = "vault" },
+			check:   func(c MinioConfig) error { _, err := c.credentials(); return err },
+			wantErr: "MINIO_CREDENTIALS",
+		},
+		{
+			name:   "env credentials",
+			modify: func(c *MinioConfig) { c.Credentials = CredentialsEnv },
+			check:  func(c MinioConfig) error { _, err := c.credentials(); return err },
+		},
+		{
+			name:    "unknown bucket lookup",
+			modify:  func(c *MinioConfig) { c.BucketLookup = "virtual" },
+			check:   func(c MinioConfig) error { _, err := c.bucketLookup(); return err },
+			wantErr: "MINIO_BUCKET_LOOKUP",
+		},
+		{
+			name:   "path bucket lookup",
+			modify: func(c *MinioConfig) { c.BucketLookup = "path" },
+			check:  func(c MinioConfig) error { _, err := c.bucketLookup(); return err },
+		},
+		{
+			name:    "unknown sse",
+			modify:  func(c *MinioConfig) { c.SSE = "sse-c" },
+			check:   func(c MinioConfig) error { _, err := c.serverSideEncryption(); return err },
+			wantErr: "MINIO_SSE",
+		},
+		{
+			name:   "sse-kms",
+			modify: func(c *MinioConfig) { c.SSE, c.SSEKMSKeyID = SSEKMS, "key-1" },
+			check:  func(c MinioConfig) error { _, err := c.serverSideEncryption(); return err },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			if tt.modify != nil {
+				tt.modify(&cfg)
+			}
+			err := tt.check(cfg)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("error = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestMinioStorageRoundTrip работает с настоящим MinIO и запускается, только если
+// задан MINIO_TEST_ENDPOINT (host:port), например для MinIO из scripts/minio_up.sh:
+//
+//	MINIO_TEST_ENDPOINT=localhost:9000 MINIO_TEST_ACCESS_KEY=minioadmin MINIO_TEST_SECRET_KEY=minioadmin go test ./internal/app/storage
+func TestMinioStorageRoundTrip(t *testing.T) {
+	endpoint := os.Getenv("MINIO_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_TEST_ENDPOINT is not set")
+	}
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		t.Fatalf("MINIO_TEST_ENDPOINT %q: %v", endpoint, err)
+	}
+	bucket := os.Getenv("MINIO_TEST_BUCKET")
+	if bucket == "" {
+		bucket = "videoprocessor-test"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	st, err := NewMinioStorage(ctx, MinioConfig{
+		Host:         host,
+		Port:         port,
+		BucketName:   bucket,
+		BucketLookup: "path",
+		Credentials:  CredentialsStatic,
+		AccessKey:    os.Getenv("MINIO_TEST_ACCESS_KEY"),
+		SecretKey:    os.Getenv("MINIO_TEST_SECRET_KEY"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := bucket + "/test-" + uuid.NewString()
+	t.Cleanup(func() { st.RemoveAll(context.Background(), prefix) })
+
+	put := func(objectPath, data string) {
+		t.Helper()
+		w, err := st.Upload(ctx, objectPath, UploadOptions{Size: int64(len(data))})
+		if err != nil {
+			t.Fatalf("Upload(%s): %v", objectPath, err)
+		}
+		if _, err := io.Copy(w, strings.NewReader(data)); err != nil {
+			t.Fatalf("write %s: %v", objectPath, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close(%s): %v", objectPath, err)
+		}
+	}
+	get := func(objectPath string) (string, error) {
+		t.Helper()
+		r, err := st.Download(ctx, objectPath)
+		if err != nil {
+			return "", err
+		}
+		if c, ok := r.(io.Closer); ok {
+			defer c.Close()
+		}
+		data, err := io.ReadAll(r)
+		return string(data), err
+	}
+
+	master, segment := prefix+"/master.m3u8", prefix+"/360p/segment_360p_0.ts"
+	put(master, "#EXTM3U\n")
+	put(segment, "segment data")
+
+	if got, err := get(segment); err != nil || got != "segment data" {
+		t.Fatalf("Download(%s) = %q, %v; want %q", segment, got, err, "segment data")
+	}
+
+	copied := prefix + "/copy/master.m3u8"
+	if err := st.Copy(ctx, master, copied); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if got, err := get(copied); err != nil || got != "#EXTM3U\n" {
+		t.Fatalf("Download(%s) = %q, %v; want copied playlist", copied, got, err)
+	}
+
+	if _, err := get(prefix + "/missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Download(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if err := st.RemoveAll(ctx, prefix); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	for _, objectPath := range []string{master, segment, copied} {
+		if _, err := get(objectPath); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Download(%s) after RemoveAll error = %v, want ErrNotFound", objectPath, err)
+		}
+	}
+}
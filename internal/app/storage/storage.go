@@ -1,19 +1,50 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"io"
 	"time"
 )
 
+// ErrNotFound is returned (wrapped) by Download when the object does not exist.
+var ErrNotFound = errors.New("object not found")
+
 type StorageProvider interface {
 	Download(pathDownload, pathLocal string) error
 	Upload(pathLocal, pathUpload string) error
 }
 
+// UploadOptions describes the object being uploaded.
+type UploadOptions struct {
+	// Size is the object size in bytes, or -1 (or 0) if unknown.
+	// A known size lets the provider avoid buffering multipart chunks.
+	Size int64
+	// ContentType of the object; detected from the object extension when empty.
+	ContentType string
+	// CacheControl header stored with the object; omitted when empty.
+	CacheControl string
+}
+
+// ObjectWriter streams an object into storage.
+// Close blocks until the object is committed and returns the storage error, if any.
+// CloseWithError aborts the upload so that no partial object is committed.
+type ObjectWriter interface {
+	io.WriteCloser
+	CloseWithError(err error) error
+}
+
 // StorageStreamProvider is an interface for storage providers
 // that support streaming uploads and downloads.
+// Cancelling ctx aborts the underlying transfer.
 type StorageStreamProvider interface {
-	Download(pathDownload string) (io.Reader, error)
-	Upload(pathUpload string) (io.WriteCloser, error)
-	GetPresignedURL(pathDownload string, expiry time.Duration) (string, error)
+	Download(ctx context.Context, pathDownload string) (io.Reader, error)
+	Upload(ctx context.Context, pathUpload string, opts UploadOptions) (ObjectWriter, error)
+	GetPresignedURL(ctx context.Context, pathDownload string, expiry time.Duration) (string, error)
+	// Copy copies an object with its metadata within the storage.
+	Copy(ctx context.Context, pathSrc, pathDst string) error
+	// Remove deletes a single object; a missing object is not an error.
+	Remove(ctx context.Context, path string) error
+	// RemoveAll deletes every object under the given "<bucket>/<prefix>" path.
+	RemoveAll(ctx context.Context, pathPrefix string) error
 }
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"io"
+	"sync"
+)
+
+// pipeObjectWriter - ObjectWriter поверх io.Pipe: данные из Write читает
+// горутина загрузки, а Close/CloseWithError ждут, пока она закончит.
+type pipeObjectWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+
+	once sync.Once
+	err  error
+}
+
+// newPipeObjectWriter запускает upload в отдельной горутине и возвращает writer,
+// данные из которого upload читает из r. Ошибка upload закрывает r через
+// CloseWithError, так что Write сразу получает настоящую причину.
+func newPipeObjectWriter(upload func(r io.Reader) error) *pipeObjectWriter {
+	pr, pw := io.Pipe()
+	w := &pipeObjectWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := upload(pr)
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.Close()
+		}
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *pipeObjectWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close завершает поток данных и ждет, пока объект будет сохранен.
+func (w *pipeObjectWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError прерывает загрузку: объект не будет сохранен.
+// С nil работает как Close.
+func (w *pipeObjectWriter) CloseWithError(cause error) error {
+	w.once.Do(func() {
+		w.pw.CloseWithError(cause)
+		w.err = <-w.done
+	})
+	return w.err
+}
@@ -0,0 +1,133 @@
+package task
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventSchemaVersion - версия схемы JobEvent. Увеличивается при несовместимых изменениях.
+const EventSchemaVersion = 1
+
+type EventType string
+
+const (
+	EventAccepted  EventType = "accepted"
+	EventStarted   EventType = "started"
+	EventProgress  EventType = "progress"
+	EventFailed    EventType = "failed"
+	EventCompleted EventType = "completed"
+	EventCancelled EventType = "cancelled"
+)
+
+// ErrCancelled - причина отмены контекста задачи по команде пользователя.
+var ErrCancelled = errors.New("job cancelled")
+
+// ErrorClass - класс ошибки обработки, по которому downstream решает, что показать пользователю.
+type ErrorClass string
+
+const (
+	ErrorClassInvalidTask ErrorClass = "invalid_task"
+	ErrorClassStorage     ErrorClass = "storage"
+	ErrorClassTranscode   ErrorClass = "transcode"
+	ErrorClassPublish     ErrorClass = "publish"
+	ErrorClassTimeout     ErrorClass = "timeout"
+	ErrorClassInternal    ErrorClass = "internal"
+)
+
+// JobError - ошибка обработки задачи с классом.
+type JobError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func NewJobError(class ErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &JobError{Class: class, Err: err}
+}
+
+func (e *JobError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// ClassOf возвращает класс самой глубокой JobError в цепочке err
+// (она точнее описывает причину) или ErrorClassInternal.
+func ClassOf(err error) ErrorClass {
+	class := ErrorClassInternal
+	var je *JobError
+	for errors.As(err, &je) {
+		class = je.Class
+		err = je.Err
+	}
+	return class
+}
+
+// Progress - прогресс обработки задачи.
+// Поля Frame, FPS, Speed, OutTime и ETA заполняются на этапе перекодирования.
+type Progress struct {
+	Stage   string  `json:"stage"`
+	Percent float64 `json:"percent"`
+
+	Frame   int64   `json:"frame,omitempty"`
+	FPS     float64 `json:"fps,omitempty"`
+	Speed   float64 `json:"speed,omitempty"`            // скорость относительно реального времени
+	OutTime float64 `json:"out_time_seconds,omitempty"` // сколько секунд видео уже обработано
+	ETA     float64 `json:"eta_seconds,omitempty"`      // оценка оставшегося времени в секундах
+}
+
+// Этапы обработки для Progress.Stage.
+const (
+	StageTranscoding = "transcoding"
+	StageUploading   = "uploading"
+)
+
+// ProgressFunc получает обновления прогресса обработки.
+type ProgressFunc func(Progress)
+
+// JobEvent - событие жизненного цикла задачи обработки видео.
+type JobEvent struct {
+	Version    int       `json:"version"`
+	Type       EventType `json:"type"`
+	VideoID    uuid.UUID `json:"video_id"`
+	UserID     int64     `json:"user_id"`
+	VideoTitle string    `json:"video_title"`
+	Attempt    int       `json:"attempt"`
+	Timestamp  time.Time `json:"timestamp"`
+
+	Progress *Progress   `json:"progress,omitempty"`
+	Error    *EventError `json:"error,omitempty"`
+	Outputs  *JobOutputs `json:"outputs,omitempty"`
+}
+
+type EventError struct {
+	Class   ErrorClass `json:"class"`
+	Message string     `json:"message"`
+	// WillRetry - задача будет повторена; false означает окончательную ошибку.
+	WillRetry bool `json:"will_retry"`
+}
+
+type JobOutputs struct {
+	MasterPlaylistURL string `json:"master_playlist_url"`
+	StorageBucket     string `json:"storage_bucket"`
+	StorageKey        string `json:"storage_key"`
+	Revision          string `json:"revision"`
+}
+
+func NewJobEvent(eventType EventType, vt VideoTask, attempt int) JobEvent {
+	return JobEvent{
+		Version:    EventSchemaVersion,
+		Type:       eventType,
+		VideoID:    vt.VideoID,
+		UserID:     vt.UserID,
+		VideoTitle: vt.VideoTitle,
+		Attempt:    attempt,
+		Timestamp:  time.Now().UTC(),
+	}
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package task
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// isolateProcessGroup запускает ffmpeg в отдельной группе процессов,
+// чтобы Ctrl+C в терминале не убивал его в обход graceful shutdown.
+func isolateProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,57 @@
+package task
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// addMasterFrameRates дописывает FRAME-RATE в EXT-X-STREAM-INF мастер-плейлиста
+// для вариантов, у которых известна частота кадров. Если ffmpeg уже записал
+// атрибут, строка не меняется.
+func addMasterFrameRates(masterPath string, qualities []Quality) error {
+	rates := make(map[string]float64, len(qualities))
+	for _, q := range qualities {
+		if q.FrameRate > 0 {
+			rates[q.Name] = q.FrameRate
+		}
+	}
+	if len(rates) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(masterPath)
+	if err != nil {
+		return fmt.Errorf("read master playlist: %w", err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "#EXT-X-STREAM-INF:") || strings.Contains(line, "FRAME-RATE=") {
+			continue
+		}
+		uri := nextURI(lines[i+1:])
+		fps, ok := rates[RenditionOf(uri)]
+		if !ok {
+			continue
+		}
+		// HLS требует не больше трех знаков после запятой
+		lines[i] = line + fmt.Sprintf(",FRAME-RATE=%.3f", fps)
+	}
+
+	if err := os.WriteFile(masterPath, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		return fmt.Errorf("write master playlist: %w", err)
+	}
+	return nil
+}
+
+// nextURI возвращает первую строку с URI (не тег и не пустую).
+func nextURI(lines []string) string {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line
+		}
+	}
+	return ""
+}
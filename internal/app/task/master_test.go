@@ -0,0 +1,81 @@
+package task
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddMasterFrameRates(t *testing.T) {
+	const master = "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=4000000,RESOLUTION=1280x720,CODECS=\"avc1.64001f,mp4a.40.2\"\nstream_720p.m3u8\n\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1000000,RESOLUTION=640x360\n\nstream_360p.m3u8\n"
+
+	tests := []struct {
+		name      string
+		master    string
+		qualities []Quality
+		want      string
+	}{
+		{
+			name:      "all variants",
+			master:    master,
+			qualities: []Quality{{Name: "720p", FrameRate: 60000.0 / 1001}, {Name: "360p", FrameRate: 30000.0 / 1001}},
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=4000000,RESOLUTION=1280x720,CODECS=\"avc1.64001f,mp4a.40.2\",FRAME-RATE=59.940\nstream_720p.m3u8\n\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1000000,RESOLUTION=640x360,FRAME-RATE=29.970\n\nstream_360p.m3u8\n",
+		},
+		{
+			name:      "unknown frame rate",
+			master:    master,
+			qualities: []Quality{{Name: "720p", FrameRate: 25}, {Name: "360p"}},
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=4000000,RESOLUTION=1280x720,CODECS=\"avc1.64001f,mp4a.40.2\",FRAME-RATE=25.000\nstream_720p.m3u8\n\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1000000,RESOLUTION=640x360\n\nstream_360p.m3u8\n",
+		},
+		{
+			name:      "no frame rates",
+			master:    master,
+			qualities: []Quality{{Name: "720p"}, {Name: "360p"}},
+			want:      master,
+		},
+		{
+			name:      "frame rate already written",
+			master:    "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000000,FRAME-RATE=30.000\nstream_360p.m3u8\n",
+			qualities: []Quality{{Name: "360p", FrameRate: 25}},
+			want:      "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000000,FRAME-RATE=30.000\nstream_360p.m3u8\n",
+		},
+		{
+			name:      "stream inf without uri",
+			master:    "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000000",
+			qualities: []Quality{{Name: "360p", FrameRate: 25}},
+			want:      "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), MastePLName)
+			if err := os.WriteFile(path, []byte(tt.master), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := addMasterFrameRates(path, tt.qualities); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("master =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing master", func(t *testing.T) {
+		err := addMasterFrameRates(filepath.Join(t.TempDir(), MastePLName), []Quality{{Name: "360p", FrameRate: 25}})
+		if err == nil {
+			t.Error("addMasterFrameRates() = nil, want error for a missing playlist")
+		}
+	})
+}
@@ -1,6 +1,11 @@
 package task
 
-import "github.com/google/uuid"
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type VideoTask struct {
 	VideoID    uuid.UUID `json:"video_id"`
@@ -12,12 +17,59 @@ type DBUpload struct {
 	VideoID    uuid.UUID `json:"video_id"`
 	UserID     int64     `json:"user_id"`
 	VideoTitle string    `json:"video_title"`
-	URL        string    `json:"video_master_playlist_url"`
+	// URL мастер-плейлиста в зависимости от PLAYBACK_URL_MODE: ключ, публичный URL или presigned URL.
+	URL string `json:"video_master_playlist_url"`
+	// Где лежит мастер-плейлист, чтобы API мог сам подписывать URL.
+	StorageBucket string `json:"storage_bucket"`
+	StorageKey    string `json:"storage_key"`
+	// Revision - ревизия результата; повторная обработка видео создает новую.
+	Revision string `json:"revision"`
 }
 
+// Output - результат обработки видео.
+type Output struct {
+	// URL - ссылка на мастер-плейлист для каталога.
+	URL string
+	// Bucket и Key - расположение мастер-плейлиста в хранилище.
+	Bucket string
+	Key    string
+	// Revision - ревизия результата.
+	Revision string
+}
+
+// ControlCommand - команда управления задачами из control-очереди.
+type ControlCommand struct {
+	Action  string    `json:"action"`
+	VideoID uuid.UUID `json:"video_id"`
+	// RequestedAt - когда отправлена команда; отмена касается только задач,
+	// поставленных в очередь раньше. Пусто - время получения команды.
+	RequestedAt time.Time `json:"requested_at,omitempty"`
+}
+
+const ControlActionCancel = "cancel"
+
 const (
 	MastePLName            = "master.m3u8"
 	VariantPlaylistPattern = "stream_%v.m3u8"   // Шаблон для плейлистов HLS
 	SegmentPattern         = "segment_%v_%d.ts" // Шаблон для сегментов HLS
 
 )
+
+// RenditionOf возвращает имя варианта, например "720p" (%v в VariantPlaylistPattern и SegmentPattern),
+// к которому относится файл результата, или пустую строку для мастер-плейлиста
+// и прочих файлов.
+func RenditionOf(file string) string {
+	if i := strings.LastIndex(file, "/"); i >= 0 {
+		file = file[i+1:]
+	}
+	switch {
+	case strings.HasPrefix(file, "stream_") && strings.HasSuffix(file, ".m3u8"):
+		return strings.TrimSuffix(strings.TrimPrefix(file, "stream_"), ".m3u8")
+	case strings.HasPrefix(file, "segment_"):
+		name := strings.TrimPrefix(file, "segment_")
+		if i := strings.LastIndex(name, "_"); i > 0 {
+			return name[:i]
+		}
+	}
+	return ""
+}
@@ -1,13 +1,19 @@
 package task
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	ffmpeg_go "github.com/u2takey/ffmpeg-go"
 )
@@ -16,15 +22,60 @@ const MaxBitrateKbps = 5000 // Максимальный битрейт в кби
 const AVC = "libx264"       // Кодек для видео
 const AAC = "aac"           // Кодек для аудио
 
+// bitsPerPixel - бит на пиксель кадра для битрейта варианта: битрейт = ширина * высота * fps * bitsPerPixel.
+const bitsPerPixel = 0.16
+
+// ffmpegStopTimeout - сколько ffmpeg дается на завершение после SIGTERM,
+// прежде чем процесс будет убит.
+const ffmpegStopTimeout = 10 * time.Second
+
+const (
+	defaultFrameRate     = 30  // если ffprobe не знает частоту кадров
+	maxFrameRate         = 30  // предел частоты кадров на нижних ступенях
+	maxProbedFrameRate   = 240 // больше - скорее всего r_frame_rate VFR-файла, а не реальная частота
+	highFrameRateMinEdge = 720 // с какой ступени сохраняется высокая частота при HighFrameRate
+)
+
 type Processer interface {
-	Process(t VideoTask, videoURL string, outputDir string) error
+	// Probe читает метаданные исходника.
+	Probe(ctx context.Context, videoURL string) (VideoMetadata, error)
+	// TranscodeTimeout - лимит времени на перекодирование исходника длительностью duration.
+	TranscodeTimeout(duration time.Duration) time.Duration
+	Process(ctx context.Context, t VideoTask, videoURL string, outputDir string, meta VideoMetadata, progress ProgressFunc) error
+}
+
+type ProcessConfig struct {
+	// ProgressInterval - как часто отдавать прогресс перекодирования.
+	ProgressInterval time.Duration `env:"PROCESS_PROGRESS_INTERVAL" envDefault:"5s"`
+
+	// ProbeTimeout - сколько ждать ffprobe.
+	ProbeTimeout time.Duration `env:"PROCESS_PROBE_TIMEOUT" envDefault:"1m"`
+	// Время на перекодирование: длительность исходника * TimeoutMultiplier,
+	// но не меньше TimeoutMin и не больше TimeoutMax.
+	// Если длительность неизвестна, используется TimeoutMax.
+	TimeoutMultiplier float64       `env:"PROCESS_TIMEOUT_MULTIPLIER" envDefault:"5"`
+	TimeoutMin        time.Duration `env:"PROCESS_TIMEOUT_MIN" envDefault:"5m"`
+	TimeoutMax        time.Duration `env:"PROCESS_TIMEOUT_MAX" envDefault:"4h"`
+
+	// SilentAudio - добавлять тихую AAC-дорожку, если в исходнике нет звука
+	// (некоторым плеерам нужна аудиодорожка в каждом варианте).
+	SilentAudio bool `env:"PROCESS_SILENT_AUDIO" envDefault:"false"`
+
+	// Autorotate - поворачивать кадр по метаданным исходника (поведение ffmpeg по умолчанию).
+	// Если выключено, ffmpeg запускается с -noautorotate и кадр остается в закодированной ориентации.
+	Autorotate bool `env:"PROCESS_AUTOROTATE" envDefault:"true"`
+
+	// HighFrameRate - сохранять частоту кадров исходника выше 30 на ступенях от 720p.
+	// Иначе частота всех вариантов ограничивается 30 (60 -> 30, 50 -> 25).
+	HighFrameRate bool `env:"PROCESS_HIGH_FPS" envDefault:"false"`
 }
 
 type VideoProcess struct {
+	cfg ProcessConfig
 }
 
-func NewVideoProcess() *VideoProcess {
-	return &VideoProcess{}
+func NewVideoProcess(cfg ProcessConfig) *VideoProcess {
+	return &VideoProcess{cfg: cfg}
 }
 
 type Quality struct {
@@ -32,85 +83,138 @@ type Quality struct {
 	Width       int
 	Height      int
 	BitrateKbps int
+	FrameRate   float64 // частота кадров варианта, 0 - неизвестна
+	// FrameRateCapped - FrameRate ниже частоты исходника, кадры прореживаются фильтром fps.
+	FrameRateCapped bool
 }
 
 // Processer реализует интерфейс Processer и отвечает за обработку видео.
-// Он принимает VideoTask, URL видео и директорию для сохранения обработанного видео.
-// Внутри он проверяет и генерирует доступные качества, а затем создает HLS-плейлисты и сегменты.
-func (vh *VideoProcess) Process(t VideoTask, videoURL string, outputDir string) error {
+// Он принимает VideoTask, URL видео, его метаданные (Probe) и директорию для сохранения
+// обработанного видео. Внутри он генерирует доступные качества, а затем создает
+// HLS-плейлисты и сегменты.
+// Отмена ctx останавливает ffmpeg.
+// Прогресс перекодирования отдается в progress не чаще ProgressInterval.
+func (vh *VideoProcess) Process(ctx context.Context, t VideoTask, videoURL string, outputDir string, meta VideoMetadata, progress ProgressFunc) error {
 
-	// Получаем доступные качества видео
-	q, err := vh.checkAndGenerateQualities(videoURL)
-	if err != nil {
-		return fmt.Errorf("error get Qualities for video (Process): %w", err)
+	// Генерируем доступные качества на основе метаданных
+	q := vh.autoConfig(meta)
+	if len(q) == 0 {
+		return fmt.Errorf("не удалось сгенерировать доступные качества для видео %s", videoURL)
 	}
 
 	slog.Debug("Сгенерированные качества", "qualities", q)
 
-	err = vh.generateHLS(videoURL, outputDir, q)
+	timeout := vh.TranscodeTimeout(meta.Duration)
+	slog.Debug("Лимит времени на перекодирование", "timeout", timeout, "duration", meta.Duration)
+	hlsCtx, cancel := context.WithTimeoutCause(ctx, timeout,
+		NewJobError(ErrorClassTimeout, fmt.Errorf("transcoding exceeded %s", timeout)))
+	defer cancel()
+
+	audio := audioFromSource
+	if !meta.HasAudio {
+		audio = audioNone
+		if vh.cfg.SilentAudio {
+			audio = audioSilent
+		}
+		slog.Debug("В исходнике нет аудиопотока", "audio", audio)
+	}
+
+	pw := newFFmpegProgressWriter(meta.Duration, vh.cfg.ProgressInterval, progress)
+	err := vh.generateHLS(hlsCtx, videoURL, outputDir, q, hlsAudio{mode: audio, duration: meta.Duration}, pw)
 	if err != nil {
 		return fmt.Errorf("error generate (Process) HLS: %w", err)
 	}
+	if err := addMasterFrameRates(filepath.Join(outputDir, MastePLName), q); err != nil {
+		return fmt.Errorf("error write frame rates to master playlist: %w", err)
+	}
 	return nil
 }
 
-// checkAndGenerateQualities проверяет метаданные видео и генерирует доступные качества.
-// Если не удается получить метаданные или сгенерировать качества, возвращает ошибку.
-func (vh *VideoProcess) checkAndGenerateQualities(videoURL string) ([]Quality, error) {
-	// Получаем метаданные видео
-	meta, err := vh.getVideoMetadata(videoURL)
-	if err != nil {
-		return nil, fmt.Errorf("не удалось получить метаданные видео: %w", err)
+// TranscodeTimeout считает лимит времени на перекодирование по длительности исходника.
+func (vh *VideoProcess) TranscodeTimeout(duration time.Duration) time.Duration {
+	if duration <= 0 || vh.cfg.TimeoutMultiplier <= 0 {
+		return vh.cfg.TimeoutMax
 	}
-	slog.Debug("Метаданные видео", "height", meta.Height, "width", meta.Width, "bitrate", meta.SourceBitrate)
+	timeout := time.Duration(float64(duration) * vh.cfg.TimeoutMultiplier)
+	return min(max(timeout, vh.cfg.TimeoutMin), vh.cfg.TimeoutMax)
+}
 
-	// Генерируем доступные качества на основе метаданных
-	qualities := vh.autoConfig(meta)
-	if len(qualities) == 0 {
-		return nil, fmt.Errorf("не удалось сгенерировать доступные качества для видео %s", videoURL)
+// Probe получает метаданные видео; ffprobe ограничен ProbeTimeout.
+func (vh *VideoProcess) Probe(ctx context.Context, videoURL string) (VideoMetadata, error) {
+	meta, err := vh.getVideoMetadata(ctx, videoURL)
+	if err != nil {
+		return meta, fmt.Errorf("не удалось получить метаданные видео: %w", err)
 	}
-
-	return qualities, nil
+	slog.Debug("Метаданные видео", "height", meta.Height, "width", meta.Width, "displayWidth", meta.DisplayWidth, "displayHeight", meta.DisplayHeight, "rotation", meta.Rotation, "frameRate", meta.FrameRate, "bitrate", meta.SourceBitrate, "duration", meta.Duration, "hasAudio", meta.HasAudio)
+	return meta, nil
 }
 
 type VideoMetadata struct {
-	Width         int
-	Height        int
-	SourceBitrate float64 // в кбит/с
+	Width  int
+	Height int
+	// Размер кадра при показе: ширина с учетом sample aspect ratio (неквадратных пикселей),
+	// стороны меняются местами при повороте на 90/270, если ffmpeg будет поворачивать кадр.
+	DisplayWidth  int
+	DisplayHeight int
+	Rotation      int           // поворот по часовой стрелке из метаданных: 0, 90, 180 или 270
+	FrameRate     float64       // средняя частота кадров, 0 - неизвестна
+	SourceBitrate float64       // в кбит/с
+	Duration      time.Duration // 0, если ffprobe не знает длительность
+	HasAudio      bool          // есть хотя бы один аудиопоток
 }
 
 type probeMetadata struct {
 	Streams []struct {
-		CodecType string `json:"codec_type"`
-		Width     int    `json:"width,omitempty"`
-		Height    int    `json:"height,omitempty"`
-		BitRate   string `json:"bit_rate,omitempty"`
+		CodecType         string `json:"codec_type"`
+		Width             int    `json:"width,omitempty"`
+		Height            int    `json:"height,omitempty"`
+		BitRate           string `json:"bit_rate,omitempty"`
+		SampleAspectRatio string `json:"sample_aspect_ratio,omitempty"`
+		AvgFrameRate      string `json:"avg_frame_rate,omitempty"`
+		RFrameRate        string `json:"r_frame_rate,omitempty"`
+		Tags              struct {
+			Rotate string `json:"rotate,omitempty"`
+		} `json:"tags"`
+		SideDataList []probeSideData `json:"side_data_list,omitempty"`
 	} `json:"streams"`
 	Format struct {
-		BitRate string `json:"bit_rate,omitempty"`
+		BitRate  string `json:"bit_rate,omitempty"`
+		Duration string `json:"duration,omitempty"`
 	} `json:"format"`
 }
 
+// probeSideData - side data видеопотока; из нее нужен только поворот (Display Matrix).
+type probeSideData struct {
+	SideDataType string  `json:"side_data_type"`
+	Rotation     float64 `json:"rotation"`
+}
+
 // getVideoMetadata получает метаданные видео с помощью ffprobe и возвращает структуру VideoMetadata.
-func (vh *VideoProcess) getVideoMetadata(videoURL string) (VideoMetadata, error) {
+func (vh *VideoProcess) getVideoMetadata(ctx context.Context, videoURL string) (VideoMetadata, error) {
 	// 1. Вызываем ffprobe для получения метаданных видео.
-	rawJSON, err := ffmpeg_go.Probe(videoURL)
+	probeCtx, cancel := context.WithTimeoutCause(ctx, vh.cfg.ProbeTimeout,
+		NewJobError(ErrorClassTimeout, fmt.Errorf("ffprobe exceeded %s", vh.cfg.ProbeTimeout)))
+	defer cancel()
+	rawJSON, err := probe(probeCtx, videoURL)
 	if err != nil {
 		return VideoMetadata{}, fmt.Errorf("не удалось вызвать ffprobe: %w", err)
 	}
 
 	// 2. Парсим полученный JSON в структуру probeMetadata.
 	var meta probeMetadata
-	if err := json.Unmarshal([]byte(rawJSON), &meta); err != nil {
+	if err := json.Unmarshal(rawJSON, &meta); err != nil {
 		return VideoMetadata{}, fmt.Errorf("ошибка парсинга JSON-ответа от ffprobe: %w", err)
 	}
 
 	// 3. Находим первый видеопоток (codec_type == "video").
 	//    Если ни одного «video» в streams нет — возвращаем ошибку.
 	var vidStream struct {
-		Width   int
-		Height  int
-		BitRate string
+		Width     int
+		Height    int
+		BitRate   string
+		SAR       string
+		Rotation  int
+		FrameRate float64
 	}
 	found := false
 	for _, s := range meta.Streams {
@@ -118,6 +222,9 @@ func (vh *VideoProcess) getVideoMetadata(videoURL string) (VideoMetadata, error)
 			vidStream.Width = s.Width
 			vidStream.Height = s.Height
 			vidStream.BitRate = s.BitRate
+			vidStream.SAR = s.SampleAspectRatio
+			vidStream.Rotation = streamRotation(s.Tags.Rotate, s.SideDataList)
+			vidStream.FrameRate = streamFrameRate(s.AvgFrameRate, s.RFrameRate)
 			found = true
 			break
 		}
@@ -126,6 +233,21 @@ func (vh *VideoProcess) getVideoMetadata(videoURL string) (VideoMetadata, error)
 		return VideoMetadata{}, fmt.Errorf("не найден видеопоток в файле %s", videoURL)
 	}
 
+	result := VideoMetadata{
+		Width:     vidStream.Width,
+		Height:    vidStream.Height,
+		Rotation:  vidStream.Rotation,
+		FrameRate: vidStream.FrameRate,
+		Duration:  parseSeconds(meta.Format.Duration),
+	}
+	result.DisplayWidth, result.DisplayHeight = displaySize(vidStream.Width, vidStream.Height, vidStream.SAR, vidStream.Rotation, vh.cfg.Autorotate)
+	for _, s := range meta.Streams {
+		if s.CodecType == "audio" {
+			result.HasAudio = true
+			break
+		}
+	}
+
 	// 4. Получаем битрейт видео.
 	//	  Сначала пробуем взять из meta.Format.BitRate, если там пусто — берем из видеопотока.
 	rawBitrate := meta.Format.BitRate
@@ -135,84 +257,284 @@ func (vh *VideoProcess) getVideoMetadata(videoURL string) (VideoMetadata, error)
 	}
 	if rawBitrate == "" {
 		slog.Debug("Пустой битрейт в видеопотоке, устанавливаем битрейт в 0")
-		return VideoMetadata{
-			Width:         vidStream.Width,
-			Height:        vidStream.Height,
-			SourceBitrate: 0,
-		}, nil
+		return result, nil
 	}
 
 	// 5. Конвертируем строку "битрейт"  в int.
 	totalBitrate, err := strconv.Atoi(rawBitrate)
 	if err != nil {
-		return VideoMetadata{
-				Width:         vidStream.Width,
-				Height:        vidStream.Height,
-				SourceBitrate: 0,
-			},
-			fmt.Errorf("ошибка преобразования bitrate (%q) в int: %w", rawBitrate, err)
-	}
-
-	return VideoMetadata{
-		Width:         vidStream.Width,
-		Height:        vidStream.Height,
-		SourceBitrate: float64(totalBitrate) / 1000, // переводим в кбит/с
-	}, nil
+		return result, fmt.Errorf("ошибка преобразования bitrate (%q) в int: %w", rawBitrate, err)
+	}
+
+	result.SourceBitrate = float64(totalBitrate) / 1000 // переводим в кбит/с
+	return result, nil
+}
+
+// probe запускает ffprobe и возвращает его JSON-вывод.
+// В отличие от ffmpeg_go.Probe процесс ffprobe завершается при отмене ctx.
+func probe(ctx context.Context, videoURL string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-show_format",
+		"-show_streams",
+		"-of", "json",
+		videoURL,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("ffprobe aborted: %w", context.Cause(ctx))
+		}
+		return nil, fmt.Errorf("[%s] %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// streamRotation возвращает поворот видеопотока по часовой стрелке (0, 90, 180 или 270).
+// Новые версии ffmpeg отдают его в side data "Display Matrix" (против часовой стрелки),
+// старые - в теге rotate.
+func streamRotation(tag string, sideData []probeSideData) int {
+	for _, sd := range sideData {
+		if sd.SideDataType == "Display Matrix" {
+			return normalizeRotation(-sd.Rotation)
+		}
+	}
+	if deg, err := strconv.ParseFloat(tag, 64); err == nil {
+		return normalizeRotation(deg)
+	}
+	return 0
+}
+
+// streamFrameRate возвращает среднюю частоту кадров видеопотока или 0, если она неизвестна.
+// avg_frame_rate точнее для VFR-записей с телефонов, r_frame_rate - запасной вариант
+// (у некоторых контейнеров avg_frame_rate равен "0/0").
+func streamFrameRate(avg, r string) float64 {
+	for _, raw := range []string{avg, r} {
+		if fps, ok := parseRatio(raw); ok && fps <= maxProbedFrameRate {
+			return fps
+		}
+	}
+	return 0
+}
+
+// displaySize возвращает размер кадра при показе: ширина умножается на sample aspect ratio,
+// а при повороте на 90/270 стороны меняются местами, если ffmpeg будет поворачивать кадр.
+func displaySize(width, height int, sar string, rotation int, autorotate bool) (int, int) {
+	// "0:1" и пустое значение - SAR неизвестен, считаем пиксели квадратными
+	if ratio, ok := parseRatio(sar); ok && ratio != 1 {
+		width = int(math.Round(float64(width) * ratio))
+	}
+	// Телефоны пишут кадр горизонтальным и помечают поворот. ffmpeg с autorotate
+	// повернет кадр до filter_complex, поэтому лестница строится уже по повернутому размеру.
+	if autorotate && (rotation == 90 || rotation == 270) {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// normalizeRotation приводит угол к 0, 90, 180 или 270, округляя до четверти оборота.
+func normalizeRotation(deg float64) int {
+	quarter := int(math.Round(deg / 90))
+	return ((quarter % 4) + 4) % 4 * 90
 }
 
+// parseRatio разбирает отношение ffprobe вида "16:9" или "30000/1001".
+// Возвращает false для пустого, нулевого или некорректного значения.
+func parseRatio(raw string) (float64, bool) {
+	num, den, ok := strings.Cut(raw, ":")
+	if !ok {
+		num, den, ok = strings.Cut(raw, "/")
+	}
+	if !ok {
+		den = "1"
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || n <= 0 || d <= 0 {
+		return 0, false
+	}
+	return n / d, true
+}
+
+// parseSeconds переводит длительность ffprobe ("12.345000") в time.Duration.
+// Для пустой или некорректной строки возвращает 0.
+func parseSeconds(raw string) time.Duration {
+	sec, err := strconv.ParseFloat(raw, 64)
+	if err != nil || sec < 0 {
+		return 0
+	}
+	return time.Duration(sec * float64(time.Second))
+}
+
+// ladderShortEdges - ступени качества по короткой стороне кадра,
+// так что 1080p - это и 1920x1080, и вертикальное 1080x1920.
+var ladderShortEdges = []int{1080, 720, 480, 360} // TODO: тут можно и битрейт сделать динамическим
+
+// autoConfig строит лестницу качеств по размеру кадра при показе: пропорции
+// исходника сохраняются, стороны округляются до четных (требование libx264).
+// Ступени выше исходника пропускаются; если исходник меньше всех ступеней,
+// остается одно качество в его собственном размере.
 func (vh *VideoProcess) autoConfig(meta VideoMetadata) []Quality {
-	maxHeightVideo := meta.Height // Не превышаем исходное
+	width, height := meta.DisplayWidth, meta.DisplayHeight
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	shortEdge := min(width, height)
+	fps := meta.FrameRate
+	if fps <= 0 {
+		fps = defaultFrameRate
+	}
 
-	// Рассчитываем битрейт для максимального качества
-	var baseRate float64 = (float64(meta.Width*meta.Height*30) * 0.2) / 1000 // кбит/с
-	if baseRate > meta.SourceBitrate {
-		baseRate = meta.SourceBitrate * 0.9 // Не превышаем исходный
+	rungs := make([]int, 0, len(ladderShortEdges))
+	for _, edge := range ladderShortEdges {
+		if edge <= shortEdge {
+			rungs = append(rungs, edge)
+		}
+	}
+	if len(rungs) == 0 {
+		rungs = append(rungs, shortEdge)
 	}
 
 	// Генерируем профили
 	var profiles []Quality
-	for _, res := range []struct {
-		w    int
-		h    int
-		name string
-	}{
-		{1920, 1080, "1080p"}, {1280, 720, "720p"}, {854, 480, "480p"}, {640, 360, "360p"}, // TODO: тут можно и битрейт сделать динамическим
-	} {
-		if res.h > maxHeightVideo {
-			continue
+	for _, edge := range rungs {
+		scale := float64(edge) / float64(shortEdge)
+		w, h := evenSize(float64(width)*scale), evenSize(float64(height)*scale)
+
+		rungFPS := fps
+		if !vh.cfg.HighFrameRate || edge < highFrameRateMinEdge {
+			rungFPS = capFrameRate(fps, maxFrameRate)
+		}
+
+		// Битрейт по пикселям и частоте кадров самой ступени, кбит/с
+		rate := float64(w*h) * rungFPS * bitsPerPixel / 1000
+		if meta.SourceBitrate > 0 && rate > meta.SourceBitrate*0.9 {
+			rate = meta.SourceBitrate * 0.9 // Не превышаем исходный
 		}
-		rate := baseRate * (float64(res.h) / float64(maxHeightVideo)) * 0.8
 		if rate > MaxBitrateKbps {
 			rate = MaxBitrateKbps // Ограничиваем битрейт
 		}
-		profiles = append(profiles, Quality{
-			Name:        res.name,
-			Width:       res.w,
-			Height:      res.h,
-			BitrateKbps: int(rate),
-		})
+		q := Quality{
+			Name:            fmt.Sprintf("%dp", min(w, h)),
+			Width:           w,
+			Height:          h,
+			BitrateKbps:     int(rate),
+			FrameRateCapped: rungFPS < fps,
+		}
+		if meta.FrameRate > 0 {
+			q.FrameRate = rungFPS
+		}
+		profiles = append(profiles, q)
 	}
 	return profiles
 }
 
+// capFrameRate делит частоту кадров на наименьшее целое, при котором она не больше limit,
+// чтобы кадры прореживались равномерно: 60 -> 30, 59.94 -> 29.97, 50 -> 25, 120 -> 30.
+func capFrameRate(fps, limit float64) float64 {
+	if fps <= limit {
+		return fps
+	}
+	return fps / math.Ceil(fps/limit)
+}
+
+// evenSize округляет сторону кадра до ближайшего четного числа, не меньше 2.
+func evenSize(x float64) int {
+	return max(int(math.Round(x/2))*2, 2)
+}
+
+// audioMode - откуда берется звук для вариантов HLS.
+type audioMode string
+
+const (
+	audioFromSource audioMode = "source" // первый аудиопоток исходника
+	audioNone       audioMode = "none"   // варианты без звука
+	audioSilent     audioMode = "silent" // тихая дорожка из anullsrc
+)
+
+type hlsAudio struct {
+	mode audioMode
+	// duration - длительность тихой дорожки; 0 - до конца видео (-shortest).
+	duration time.Duration
+}
+
+// source возвращает начало цепочки фильтров, дающей звук, без asplit.
+func (a hlsAudio) source() string {
+	if a.mode != audioSilent {
+		return "[0:a]"
+	}
+	// anullsrc бесконечный, поэтому обрезаем его по длительности видео
+	src := "anullsrc=channel_layout=stereo:sample_rate=48000"
+	if a.duration > 0 {
+		src += fmt.Sprintf(",atrim=duration=%.3f", a.duration.Seconds())
+	}
+	return src + ","
+}
+
 // generateHLS создает HLS-плейлисты и сегменты для видео с заданными качествами
 // с помощью ffmpeg-go.
 // Он принимает URL входного видео, директорию для сохранения выходных файлов и срез качеств.
-func (vh *VideoProcess) generateHLS(inputURL string, outputDir string, qualities []Quality) error {
+// Если звука нет (audio.mode == audioNone), варианты собираются только из видео.
+// Прогресс ffmpeg (-progress pipe:1) пишется в progress.
+func (vh *VideoProcess) generateHLS(ctx context.Context, inputURL string, outputDir string, qualities []Quality, audio hlsAudio, progress io.Writer) error {
 	logger := slog.With(
 		"method", "generateHLS",
 		"inputURL", inputURL,
 		"outputDir", outputDir,
 		"qualities", qualities,
+		"audio", audio.mode,
 	)
 	logger.Debug("Начинаем генерацию HLS")
 	// TODO: сделать аудио динамическим
 	//   Сейчас аудио кодек и аудио_битрейт жестко прописаны.
-	n := len(qualities)
-	if n == 0 {
+	if len(qualities) == 0 {
 		return fmt.Errorf("empty qualities slice")
 	}
 
+	args := hlsOutputArgs(outputDir, qualities, audio)
+	logger.Debug("ffmpeg", "args", args)
+
+	variantPlaylistPattern := filepath.Join(outputDir, VariantPlaylistPattern)
+
+	inputArgs := ffmpeg_go.KwArgs{}
+	if !vh.cfg.Autorotate {
+		inputArgs["noautorotate"] = ""
+	}
+
+	// Сборка и запуск ffmpeg-команды
+	cmd := ffmpeg_go.
+		OutputContext(
+			ctx,
+			[]*ffmpeg_go.Stream{ffmpeg_go.Input(inputURL, inputArgs)},
+			variantPlaylistPattern,
+			args,
+		).WithErrorOutput(&slogWriter{level: slog.LevelDebug}).
+		WithOutput(progress).
+		Compile()
+
+	// При отмене ctx сначала просим ffmpeg завершиться сам,
+	// и только если он не успел - убиваем процесс.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = ffmpegStopTimeout
+	isolateProcessGroup(cmd)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("ffmpeg execution aborted: %w", context.Cause(ctx))
+		}
+		return fmt.Errorf("ffmpeg execution failed: %w", err)
+	}
+
+	return nil
+}
+
+// hlsOutputArgs собирает выходные аргументы ffmpeg: filter_complex, разбивающий видео
+// (и звук, если он есть) на варианты, map, кодеки с битрейтами и var_stream_map.
+func hlsOutputArgs(outputDir string, qualities []Quality, audio hlsAudio) ffmpeg_go.KwArgs {
+	n := len(qualities)
 	var (
 		splitLabels []string // будет ["[v0]", "[v1]", "[v2]", ...]
 		scaleParts  []string // будет ["[v0]scale=WxH[v0out]", "[v1]scale=WxH[v1out]", ...]
@@ -223,32 +545,37 @@ func (vh *VideoProcess) generateHLS(inputURL string, outputDir string, qualities
 
 	for i, q := range qualities {
 		splitLabels[i] = fmt.Sprintf("[v%d]", i)
-		scaleParts[i] = fmt.Sprintf("[v%d]scale=%d:%d[v%dout]", i, q.Width, q.Height, i)
+		// setsar=1: размеры уже посчитаны для показа, пиксели на выходе квадратные
+		chain := fmt.Sprintf("scale=%d:%d,setsar=1", q.Width, q.Height)
+		if q.FrameRateCapped {
+			chain = fmt.Sprintf("fps=%s,%s", strconv.FormatFloat(q.FrameRate, 'f', -1, 64), chain)
+		}
+		scaleParts[i] = fmt.Sprintf("[v%d]%s[v%dout]", i, chain, i)
 		audioLabels[i] = fmt.Sprintf("[a%d]", i)
 	}
 
 	// Конструируем окончательную строку filter_complex
 	filterComplex := fmt.Sprintf(
-		"[0:v]split=%d%s;%s;[0:a]asplit=%d%s",
+		"[0:v]split=%d%s;%s",
 		n,
 		strings.Join(splitLabels, ""),
 		strings.Join(scaleParts, ";"),
-		n,
-		strings.Join(audioLabels, ""),
 	)
-
-	logger.Debug("filter_complex", "value", filterComplex)
+	withAudio := audio.mode != audioNone
+	if withAudio {
+		filterComplex += fmt.Sprintf(";%sasplit=%d%s", audio.source(), n, strings.Join(audioLabels, ""))
+	}
 
 	// Map
 	mapLabels := make([]string, n)
 	for i := 0; i < n; i++ {
 		mapLabels[i] = fmt.Sprintf("[v%dout]", i)
-		mapLabels = append(mapLabels, fmt.Sprintf("[a%d]", i))
+		if withAudio {
+			mapLabels = append(mapLabels, fmt.Sprintf("[a%d]", i))
+		}
 	}
 	// mapLabels = append(mapLabels, "0:a")
 
-	logger.Debug("mapLabels", "value", mapLabels)
-
 	// Формируем сами KwArgs:
 	args := ffmpeg_go.KwArgs{
 		"filter_complex":       filterComplex,
@@ -257,9 +584,12 @@ func (vh *VideoProcess) generateHLS(inputURL string, outputDir string, qualities
 		"hls_time":             "6",
 		"hls_segment_filename": filepath.Join(outputDir, SegmentPattern),
 		"hls_playlist_type":    "vod",
+		"progress":             "pipe:1", // машиночитаемый прогресс в stdout
+		"nostats":              "",
+	}
+	if audio.mode == audioSilent && audio.duration <= 0 {
+		args["shortest"] = "" // Длительность неизвестна, тихую дорожку ограничивает видео
 	}
-
-	logger.Debug("ffmpeg", "args", args)
 
 	for i, q := range qualities {
 		// Video кодек для каждого качества.
@@ -271,6 +601,10 @@ func (vh *VideoProcess) generateHLS(inputURL string, outputDir string, qualities
 		keyBitrate := fmt.Sprintf("b:v:%d", i)
 		args[keyBitrate] = fmt.Sprintf("%dk", q.BitrateKbps)
 
+		if !withAudio {
+			continue
+		}
+
 		// Audio кодек для каждого качества.
 		keyAudioCodec := fmt.Sprintf("c:a:%d", i)
 		args[keyAudioCodec] = AAC
@@ -284,26 +618,15 @@ func (vh *VideoProcess) generateHLS(inputURL string, outputDir string, qualities
 	// Для var_stream_map собираем кусок v:i,name:Name_i" и объединяем через пробел.
 	var vsEntries []string
 	for i, q := range qualities {
-		vsEntries = append(vsEntries, fmt.Sprintf("v:%d,a:%d,name:%s", i, i, q.Name))
+		if withAudio {
+			vsEntries = append(vsEntries, fmt.Sprintf("v:%d,a:%d,name:%s", i, i, q.Name))
+		} else {
+			vsEntries = append(vsEntries, fmt.Sprintf("v:%d,name:%s", i, q.Name))
+		}
 	}
 	args["var_stream_map"] = strings.Join(vsEntries, " ")
 	args["master_pl_name"] = MastePLName
-
-	variantPlaylistPattern := filepath.Join(outputDir, VariantPlaylistPattern)
-
-	// Сборка и запуск ffmpeg-команды
-	proc := ffmpeg_go.
-		Input(inputURL).
-		Output(
-			variantPlaylistPattern,
-			args,
-		).WithErrorOutput(&slogWriter{level: slog.LevelDebug}).
-		WithOutput(&slogWriter{level: slog.LevelDebug})
-	if err := proc.Run(); err != nil {
-		return fmt.Errorf("ffmpeg execution failed: %w", err)
-	}
-
-	return nil
+	return args
 }
 
 type slogWriter struct {
@@ -0,0 +1,300 @@
+package task
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAutoConfigBitrate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ProcessConfig
+		meta VideoMetadata
+		want map[string]int // имя варианта -> битрейт, кбит/с
+	}{
+		{
+			name: "1080p59.94 without source bitrate",
+			meta: VideoMetadata{DisplayWidth: 1920, DisplayHeight: 1080, FrameRate: 60000.0 / 1001},
+			want: map[string]int{"1080p": 5000, "720p": 4419, "480p": 1965, "360p": 1104},
+		},
+		{
+			name: "high frame rate keeps 60 on 720p",
+			cfg:  ProcessConfig{HighFrameRate: true},
+			meta: VideoMetadata{DisplayWidth: 1920, DisplayHeight: 1080, FrameRate: 60},
+			want: map[string]int{"1080p": 5000, "720p": 5000, "480p": 1967, "360p": 1105},
+		},
+		{
+			name: "cinema 3840x1600@24",
+			meta: VideoMetadata{DisplayWidth: 3840, DisplayHeight: 1600, FrameRate: 24},
+			want: map[string]int{"1080p": 5000, "720p": 4777, "480p": 2123, "360p": 1194},
+		},
+		{
+			name: "limited by source bitrate",
+			meta: VideoMetadata{DisplayWidth: 1280, DisplayHeight: 720, FrameRate: 30, SourceBitrate: 2000},
+			want: map[string]int{"720p": 1800, "480p": 1800, "360p": 1105},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewVideoProcess(tt.cfg).autoConfig(tt.meta)
+			if len(got) != len(tt.want) {
+				t.Fatalf("autoConfig() = %+v, want %d qualities", got, len(tt.want))
+			}
+			for _, q := range got {
+				if want, ok := tt.want[q.Name]; !ok || q.BitrateKbps != want {
+					t.Errorf("%s (%dx%d@%.2f) bitrate = %d, want %d", q.Name, q.Width, q.Height, q.FrameRate, q.BitrateKbps, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHLSOutputArgs(t *testing.T) {
+	qualities := []Quality{
+		{Name: "720p", Width: 1280, Height: 720, BitrateKbps: 4000, FrameRate: 30, FrameRateCapped: true},
+		{Name: "360p", Width: 640, Height: 360, BitrateKbps: 1000},
+	}
+	const video = "[0:v]split=2[v0][v1];[v0]fps=30,scale=1280:720,setsar=1[v0out];[v1]scale=640:360,setsar=1[v1out]"
+
+	tests := []struct {
+		name          string
+		audio         hlsAudio
+		filterComplex string
+		maps          []string
+		varStreamMap  string
+		shortest      bool
+	}{
+		{
+			name:          "source audio",
+			audio:         hlsAudio{mode: audioFromSource},
+			filterComplex: video + ";[0:a]asplit=2[a0][a1]",
+			maps:          []string{"[v0out]", "[v1out]", "[a0]", "[a1]"},
+			varStreamMap:  "v:0,a:0,name:720p v:1,a:1,name:360p",
+		},
+		{
+			name:          "no audio",
+			audio:         hlsAudio{mode: audioNone},
+			filterComplex: video,
+			maps:          []string{"[v0out]", "[v1out]"},
+			varStreamMap:  "v:0,name:720p v:1,name:360p",
+		},
+		{
+			name:          "silent audio with duration",
+			audio:         hlsAudio{mode: audioSilent, duration: 12500 * time.Millisecond},
+			filterComplex: video + ";anullsrc=channel_layout=stereo:sample_rate=48000,atrim=duration=12.500,asplit=2[a0][a1]",
+			maps:          []string{"[v0out]", "[v1out]", "[a0]", "[a1]"},
+			varStreamMap:  "v:0,a:0,name:720p v:1,a:1,name:360p",
+		},
+		{
+			name:          "silent audio without duration",
+			audio:         hlsAudio{mode: audioSilent},
+			filterComplex: video + ";anullsrc=channel_layout=stereo:sample_rate=48000,asplit=2[a0][a1]",
+			maps:          []string{"[v0out]", "[v1out]", "[a0]", "[a1]"},
+			varStreamMap:  "v:0,a:0,name:720p v:1,a:1,name:360p",
+			shortest:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := hlsOutputArgs("/out", qualities, tt.audio)
+
+			if got := args["filter_complex"]; got != tt.filterComplex {
+				t.Errorf("filter_complex = %q, want %q", got, tt.filterComplex)
+			}
+			if got := args["map"]; !slices.Equal(got.([]string), tt.maps) {
+				t.Errorf("map = %q, want %q", got, tt.maps)
+			}
+			if got := args["var_stream_map"]; got != tt.varStreamMap {
+				t.Errorf("var_stream_map = %q, want %q", got, tt.varStreamMap)
+			}
+			if _, ok := args["shortest"]; ok != tt.shortest {
+				t.Errorf("shortest set = %v, want %v", ok, tt.shortest)
+			}
+			if args["b:v:0"] != "4000k" || args["b:v:1"] != "1000k" {
+				t.Errorf("video bitrates = %v, %v; want 4000k, 1000k", args["b:v:0"], args["b:v:1"])
+			}
+			_, hasAudioCodec := args["c:a:0"]
+			if wantAudio := tt.audio.mode != audioNone; hasAudioCodec != wantAudio {
+				t.Errorf("c:a:0 set = %v, want %v", hasAudioCodec, wantAudio)
+			}
+		})
+	}
+}
+
+func TestAutoConfigLadder(t *testing.T) {
+	type rung struct {
+		name string
+		w, h int
+	}
+	tests := []struct {
+		name          string
+		width, height int
+		want          []rung
+	}{
+		{"landscape 1080p", 1920, 1080, []rung{{"1080p", 1920, 1080}, {"720p", 1280, 720}, {"480p", 854, 480}, {"360p", 640, 360}}},
+		{"portrait 1080p", 1080, 1920, []rung{{"1080p", 1080, 1920}, {"720p", 720, 1280}, {"480p", 480, 854}, {"360p", 360, 640}}},
+		{"4:3", 1440, 1080, []rung{{"1080p", 1440, 1080}, {"720p", 960, 720}, {"480p", 640, 480}, {"360p", 480, 360}}},
+		{"anamorphic PAL display size", 1024, 576, []rung{{"480p", 854, 480}, {"360p", 640, 360}}},
+		{"between rungs", 1280, 600, []rung{{"480p", 1024, 480}, {"360p", 768, 360}}},
+		{"below 360p", 426, 240, []rung{{"240p", 426, 240}}},
+		{"below 360p, odd size", 427, 241, []rung{{"242p", 428, 242}}},
+		{"unknown size", 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewVideoProcess(ProcessConfig{}).autoConfig(VideoMetadata{DisplayWidth: tt.width, DisplayHeight: tt.height})
+			if len(got) != len(tt.want) {
+				t.Fatalf("autoConfig() = %+v, want %+v", got, tt.want)
+			}
+			for i, w := range tt.want {
+				if got[i].Name != w.name || got[i].Width != w.w || got[i].Height != w.h {
+					t.Errorf("quality %d = %s %dx%d, want %s %dx%d", i, got[i].Name, got[i].Width, got[i].Height, w.name, w.w, w.h)
+				}
+			}
+		})
+	}
+}
+
+func TestDisplaySize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		sar           string
+		rotation      int
+		autorotate    bool
+		wantW, wantH  int
+	}{
+		{"square pixels", 1920, 1080, "1:1", 0, true, 1920, 1080},
+		{"unknown sar", 1920, 1080, "0:1", 0, true, 1920, 1080},
+		{"no sar", 1920, 1080, "", 0, true, 1920, 1080},
+		{"anamorphic PAL 16:9", 720, 576, "64:45", 0, true, 1024, 576},
+		{"anamorphic NTSC 4:3", 720, 480, "8:9", 0, true, 640, 480},
+		{"rotated 90", 1920, 1080, "1:1", 90, true, 1080, 1920},
+		{"rotated 270", 1920, 1080, "", 270, true, 1080, 1920},
+		{"rotated 180", 1920, 1080, "", 180, true, 1920, 1080},
+		{"rotated without autorotate", 1920, 1080, "", 90, false, 1920, 1080},
+		{"rotated anamorphic", 720, 576, "64:45", 90, true, 576, 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h := displaySize(tt.width, tt.height, tt.sar, tt.rotation, tt.autorotate)
+			if w != tt.wantW || h != tt.wantH {
+				t.Errorf("displaySize() = %dx%d, want %dx%d", w, h, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestStreamRotation(t *testing.T) {
+	matrix := func(rotation float64) []probeSideData {
+		return []probeSideData{{SideDataType: "Display Matrix", Rotation: rotation}}
+	}
+	tests := []struct {
+		name     string
+		tag      string
+		sideData []probeSideData
+		want     int
+	}{
+		{"none", "", nil, 0},
+		{"display matrix counterclockwise", "", matrix(-90), 90},
+		{"display matrix clockwise", "", matrix(90), 270},
+		{"display matrix 180", "", matrix(180), 180},
+		{"display matrix -180", "", matrix(-180), 180},
+		{"display matrix imprecise", "", matrix(-89.98), 90},
+		{"rotate tag", "90", nil, 90},
+		{"negative rotate tag", "-90", nil, 270},
+		{"rotate tag 270", "270", nil, 270},
+		{"display matrix wins over tag", "180", matrix(-90), 90},
+		{"other side data", "90", []probeSideData{{SideDataType: "Stereo 3D"}}, 90},
+		{"bad tag", "abc", nil, 0},
+	}
+
+	for _, tt := range tests {
+		if got := streamRotation(tt.tag, tt.sideData); got != tt.want {
+			t.Errorf("%s: streamRotation(%q, %+v) = %d, want %d", tt.name, tt.tag, tt.sideData, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeRotation(t *testing.T) {
+	tests := []struct {
+		deg  float64
+		want int
+	}{
+		{0, 0}, {90, 90}, {-90, 270}, {270, 270}, {360, 0}, {450, 90}, {-270, 90}, {-540, 180}, {44, 0}, {46, 90},
+	}
+	for _, tt := range tests {
+		if got := normalizeRotation(tt.deg); got != tt.want {
+			t.Errorf("normalizeRotation(%v) = %d, want %d", tt.deg, got, tt.want)
+		}
+	}
+}
+
+func TestParseRatio(t *testing.T) {
+	tests := []struct {
+		raw    string
+		want   float64
+		wantOK bool
+	}{
+		{"16:9", 16.0 / 9, true},
+		{"30000/1001", 30000.0 / 1001, true},
+		{"25/1", 25, true},
+		{"25", 25, true},
+		{"0/0", 0, false},
+		{"0:1", 0, false},
+		{"1/0", 0, false},
+		{"", 0, false},
+		{"N/A", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRatio(tt.raw)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRatio(%q) = %v, %v; want %v, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestStreamFrameRate(t *testing.T) {
+	tests := []struct {
+		name   string
+		avg, r string
+		want   float64
+	}{
+		{"ntsc", "30000/1001", "30000/1001", 30000.0 / 1001},
+		{"avg preferred", "2997/100", "30/1", 29.97},
+		{"avg unknown", "0/0", "25/1", 25},
+		{"vfr r_frame_rate", "0/0", "90000/1", 0},
+		{"vfr avg", "1439/48", "90000/1", 1439.0 / 48},
+		{"unknown", "", "0/0", 0},
+	}
+	for _, tt := range tests {
+		if got := streamFrameRate(tt.avg, tt.r); got != tt.want {
+			t.Errorf("%s: streamFrameRate(%q, %q) = %v, want %v", tt.name, tt.avg, tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestCapFrameRate(t *testing.T) {
+	tests := []struct {
+		fps, limit, want float64
+	}{
+		{24, 30, 24},
+		{30, 30, 30},
+		{60, 30, 30},
+		{60000.0 / 1001, 30, 30000.0 / 1001},
+		{50, 30, 25},
+		{120, 30, 30},
+		{144, 30, 144.0 / 5},
+		{90, 60, 45},
+	}
+	for _, tt := range tests {
+		if got := capFrameRate(tt.fps, tt.limit); got != tt.want {
+			t.Errorf("capFrameRate(%v, %v) = %v, want %v", tt.fps, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package task
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ffmpegProgressWriter разбирает вывод ffmpeg `-progress pipe:1`.
+// ffmpeg пишет блоки строк key=value, каждый блок заканчивается строкой
+// progress=continue или progress=end. По каждому блоку считается процент
+// относительно длительности исходника и оставшееся время; в onProgress
+// обновления уходят не чаще interval (последнее - всегда).
+// onProgress может публиковать событие и ждать подтверждения брокера, поэтому
+// вызывается вне блокировки с копией прогресса.
+type ffmpegProgressWriter struct {
+	duration   time.Duration
+	interval   time.Duration
+	onProgress ProgressFunc
+
+	mu       sync.Mutex
+	buf      []byte
+	current  Progress
+	lastSent time.Time
+}
+
+func newFFmpegProgressWriter(duration, interval time.Duration, onProgress ProgressFunc) *ffmpegProgressWriter {
+	return &ffmpegProgressWriter{
+		duration:   duration,
+		interval:   interval,
+		onProgress: onProgress,
+		current:    Progress{Stage: StageTranscoding},
+	}
+}
+
+// Write реализует интерфейс io.Writer
+func (w *ffmpegProgressWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	var updates []Progress
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+		if update, ok := w.parseLine(line); ok {
+			updates = append(updates, update)
+		}
+	}
+	w.mu.Unlock()
+
+	if w.onProgress != nil {
+		for _, update := range updates {
+			w.onProgress(update)
+		}
+	}
+	return len(p), nil
+}
+
+// parseLine разбирает строку key=value; в конце блока возвращает обновление,
+// если его пора отправить.
+func (w *ffmpegProgressWriter) parseLine(line string) (Progress, bool) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return Progress{}, false
+	}
+	value = strings.TrimSpace(value)
+
+	switch key {
+	case "frame":
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			w.current.Frame = v
+		}
+	case "fps":
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			w.current.FPS = v
+		}
+	case "out_time_us":
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil && v >= 0 {
+			w.current.OutTime = (time.Duration(v) * time.Microsecond).Seconds()
+		}
+	case "speed":
+		// "1.23x" или "N/A"
+		if v, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+			w.current.Speed = v
+		}
+	case "progress":
+		return w.flush(value == "end")
+	}
+	return Progress{}, false
+}
+
+// flush считает процент и ETA по накопленному блоку и возвращает обновление,
+// если с прошлого прошло не меньше interval (или блок последний).
+func (w *ffmpegProgressWriter) flush(final bool) (Progress, bool) {
+	if w.duration > 0 {
+		total := w.duration.Seconds()
+		w.current.Percent = min(w.current.OutTime/total*100, 100)
+		if w.current.Speed > 0 {
+			w.current.ETA = max(total-w.current.OutTime, 0) / w.current.Speed
+		}
+	}
+	if final {
+		w.current.Percent = 100
+		w.current.ETA = 0
+	}
+
+	now := time.Now()
+	if !final && now.Sub(w.lastSent) < w.interval {
+		return Progress{}, false
+	}
+	w.lastSent = now
+	return w.current, true
+}
@@ -0,0 +1,91 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFFmpegProgressWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		interval time.Duration
+		writes   []string
+		want     []Progress
+	}{
+		{
+			name:     "split writes",
+			duration: 10 * time.Second,
+			writes:   []string{"frame=10\nfps=2", "5.0\nout_time_us=5000", "000\nspeed=2.0x\nprogress=continue\n"},
+			want: []Progress{
+				{Stage: StageTranscoding, Percent: 50, Frame: 10, FPS: 25, Speed: 2, OutTime: 5, ETA: 2.5},
+			},
+		},
+		{
+			name:     "N/A values",
+			duration: 10 * time.Second,
+			writes:   []string{"frame=0\nfps=0.00\nout_time_us=N/A\nspeed=N/A\nprogress=continue\n"},
+			want:     []Progress{{Stage: StageTranscoding}},
+		},
+		{
+			name:     "crlf and end",
+			duration: 10 * time.Second,
+			writes:   []string{"out_time_us=9000000\r\nspeed=1x\r\nprogress=continue\r\n", "out_time_us=10000000\r\nprogress=end\r\n"},
+			want: []Progress{
+				{Stage: StageTranscoding, Percent: 90, Speed: 1, OutTime: 9, ETA: 1},
+				{Stage: StageTranscoding, Percent: 100, Speed: 1, OutTime: 10},
+			},
+		},
+		{
+			name:   "unknown duration",
+			writes: []string{"out_time_us=5000000\nspeed=1x\nprogress=continue\n"},
+			want:   []Progress{{Stage: StageTranscoding, Speed: 1, OutTime: 5}},
+		},
+		{
+			name:     "out time past duration",
+			duration: 4 * time.Second,
+			writes:   []string{"out_time_us=5000000\nspeed=1x\nprogress=continue\n"},
+			want:     []Progress{{Stage: StageTranscoding, Percent: 100, Speed: 1, OutTime: 5}},
+		},
+		{
+			name:     "throttled, end always sent",
+			duration: 10 * time.Second,
+			interval: time.Hour,
+			writes:   []string{"out_time_us=1000000\nprogress=continue\nout_time_us=2000000\nprogress=continue\n", "out_time_us=3000000\nprogress=end\n"},
+			want: []Progress{
+				{Stage: StageTranscoding, Percent: 10, OutTime: 1},
+				{Stage: StageTranscoding, Percent: 100, OutTime: 3},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Progress
+			var w *ffmpegProgressWriter
+			w = newFFmpegProgressWriter(tt.duration, tt.interval, func(p Progress) {
+				// onProgress вызывается вне блокировки writer'а
+				if !w.mu.TryLock() {
+					t.Error("onProgress called with the writer locked")
+				} else {
+					w.mu.Unlock()
+				}
+				got = append(got, p)
+			})
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("updates = %+v, want %+v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("update %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}